@@ -40,4 +40,6 @@ var backupMysqlFilesystemCmd = &cobra.Command{
 
 func init() {
 	backupMysqlCmd.AddCommand(backupMysqlFilesystemCmd)
+	filesystemFs := LoadFilesystemFlags(backupMysqlFilesystemCmd.Name())
+	backupMysqlFilesystemCmd.Flags().AddFlagSet(filesystemFs)
 }
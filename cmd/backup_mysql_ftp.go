@@ -0,0 +1,44 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"log/slog"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.megpoid.dev/go-s3-backup/commands"
+)
+
+var backupMysqlFTPCmd = &cobra.Command{
+	Use:     "ftp",
+	Short:   "Connect to FTP store",
+	GroupID: "store",
+	PreRun: func(cmd *cobra.Command, _ []string) {
+		cobra.CheckErr(viper.BindPFlags(cmd.Flags()))
+	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		slog.Info("Run", "method", cmd.Parent().Parent().Name(), "service", cmd.Parent().Name(), "store", cmd.Name())
+		return commands.RunTask(cmd.Parent().Parent().Name(), cmd.Parent().Name(), cmd.Name())
+	},
+}
+
+func init() {
+	backupMysqlCmd.AddCommand(backupMysqlFTPCmd)
+	ftpFs := LoadFTPFlags(backupMysqlFTPCmd.Name())
+	backupMysqlFTPCmd.Flags().AddFlagSet(ftpFs)
+}
@@ -40,4 +40,6 @@ var backupPostgresFilesystemCmd = &cobra.Command{
 
 func init() {
 	backupPostgresCmd.AddCommand(backupPostgresFilesystemCmd)
+	filesystemFs := LoadFilesystemFlags(backupPostgresFilesystemCmd.Name())
+	backupPostgresFilesystemCmd.Flags().AddFlagSet(filesystemFs)
 }
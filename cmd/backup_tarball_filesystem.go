@@ -42,4 +42,6 @@ func init() {
 	backupTarballCmd.AddCommand(backupTarballFilesystemCmd)
 	tarballFs := LoadTarballFlags(backupTarballFilesystemCmd.Name())
 	backupTarballFilesystemCmd.Flags().AddFlagSet(tarballFs)
+	filesystemFs := LoadFilesystemFlags(backupTarballFilesystemCmd.Name())
+	backupTarballFilesystemCmd.Flags().AddFlagSet(filesystemFs)
 }
@@ -16,7 +16,11 @@ limitations under the License.
 
 package cmd
 
-import "github.com/spf13/pflag"
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
 
 func LoadDefaultFlags(name string) *pflag.FlagSet {
 	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
@@ -29,6 +33,15 @@ func LoadBackupFlags(name string) *pflag.FlagSet {
 	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
 	fs.String("schedule", "@daily", "Cron schedule")
 	fs.Int("max-backups", 5, "Max backups to keep (0 to disable the feature)")
+	fs.Int("keep-daily", 0, "Number of days to keep the most recent backup of")
+	fs.Int("keep-weekly", 0, "Number of weeks to keep the most recent backup of")
+	fs.Int("keep-monthly", 0, "Number of months to keep the most recent backup of")
+	fs.Int("keep-yearly", 0, "Number of years to keep the most recent backup of")
+	fs.Int("max-age-days", 0, "Remove backups older than this number of days (0 to disable)")
+	fs.Int("min-backups", 0, "Number of most recent backups that are never removed")
+	fs.Int64("max-size", 0, "Size in MiB of the backups with the same name prefix before the oldest are removed (0 to disable)")
+	fs.Int64("max-store-size", 0, "Size in MiB of all the backups of the store before the oldest are removed (0 to disable)")
+	fs.Int("upload-concurrency", 4, "Number of backups uploaded at the same time")
 	return fs
 }
 
@@ -92,6 +105,22 @@ func LoadTarballFlags(name string) *pflag.FlagSet {
 	return fs
 }
 
+func LoadPresignFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("restore-file", "", "Presign this file instead of searching for the most recent")
+	fs.String("restore-prefix", "", "Name prefix to filter when searching the backup")
+	fs.Duration("presign-expires", time.Hour, "Time until the url expires")
+	fs.Duration("presign-max-expires", 12*time.Hour, "Maximum time until the url expires (up to 168h)")
+	return fs
+}
+
+func LoadVersionsFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("restore-file", "", "List the versions of this file instead of every backup")
+	fs.String("restore-prefix", "", "Name prefix to filter the backups")
+	return fs
+}
+
 func LoadS3Flags(name string) *pflag.FlagSet {
 	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
 	fs.String("s3-endpoint", "", "S3 endpoint")
@@ -99,6 +128,187 @@ func LoadS3Flags(name string) *pflag.FlagSet {
 	fs.String("s3-bucket", "", "S3 bucket")
 	fs.String("s3-prefix", "", "S3 prefix")
 	fs.Bool("s3-force-path-style", false, "S3 force path style (needed for minio)")
+	fs.String("s3-access-key", "", "S3 access key (default is the AWS credential chain)")
+	fs.String("s3-access-key-file", "", "S3 access key file")
+	fs.String("s3-secret-key", "", "S3 secret key")
+	fs.String("s3-secret-key-file", "", "S3 secret key file")
+	fs.String("s3-session-token", "", "S3 session token")
+	fs.String("s3-session-token-file", "", "S3 session token file")
+	fs.String("s3-profile", "", "AWS profile name from the shared config/credentials files")
+	fs.String("s3-shared-credentials-file", "", "AWS shared credentials file (default is ~/.aws/credentials)")
+	fs.String("s3-role-arn", "", "ARN of the IAM role to assume")
+	fs.String("s3-role-external-id", "", "External ID used to assume the role")
+	fs.String("s3-role-external-id-file", "", "External ID file")
+	fs.String("s3-role-session-name", "", "Session name used to assume the role")
+	fs.Duration("s3-role-duration", 0, "Duration of the assumed role credentials (0 to use the SDK default)")
+	fs.String("s3-web-identity-token-file", "", "Web identity token file used to assume the role (IRSA)")
+	fs.String("s3-sts-endpoint", "", "STS endpoint used to assume roles (default is the AWS endpoint of the region)")
+	fs.String("s3-sse", "", "Server-side encryption (AES256 for SSE-S3, aws:kms for SSE-KMS)")
+	fs.String("s3-sse-kms-key-id", "", "KMS key ID used on SSE-KMS (default is the AWS managed key)")
+	fs.StringSlice("s3-sse-kms-context", nil, "SSE-KMS encryption context, in key=value format")
+	fs.String("s3-sse-customer-key-file", "", "File with the 256-bit SSE-C customer key, raw or base64 encoded")
+	fs.String("s3-storage-class", "", "Storage class of the uploaded backups, for example STANDARD_IA or GLACIER (default is STANDARD)")
+	fs.Int64("s3-restore-days", 1, "Number of days that a restored copy of an archived backup is kept")
+	fs.String("s3-restore-tier", "Standard", "Retrieval tier used to restore archived backups (Expedited, Standard, Bulk)")
+	fs.Duration("s3-restore-timeout", 48*time.Hour, "Maximum time to wait for the restore of an archived backup (0 to wait forever)")
+	fs.Duration("s3-restore-poll-interval", time.Minute, "Time between checks of the restore status of an archived backup")
+	fs.String("s3-object-lock-mode", "", "Object Lock mode set on every upload (GOVERNANCE, COMPLIANCE)")
+	fs.Int("s3-object-lock-days", 0, "Number of days that the uploaded backups are locked")
+	fs.StringSlice("s3-tags", nil, "Tags set on every upload, either a metadata name or a key=value pair (needs s3:PutObjectTagging)")
+	fs.String("s3-version-id", "", "Version of the object to restore on versioned buckets")
+	fs.Int64("s3-part-size", 0, "Part size in MiB of multipart uploads (0 to use the default)")
+	fs.Bool("s3-resumable-upload", false, "Keep the state of multipart uploads to resume them after a failure")
+	fs.Int("s3-upload-retries", 3, "Number of times that a failed resumable upload is retried")
+	fs.Duration("s3-stale-upload-age", 24*time.Hour, "Abort unfinished multipart uploads older than this (0 to disable)")
+	fs.String("s3-ca-file", "", "CA bundle used to verify the S3 endpoint certificate")
+	fs.String("s3-client-cert-file", "", "Client certificate used on mutual TLS")
+	fs.String("s3-client-key-file", "", "Client key used on mutual TLS")
+	fs.String("s3-proxy", "", "Proxy url used on the S3 requests (default is from the environment)")
+	fs.Bool("s3-insecure-skip-verify", false, "Skip the S3 endpoint certificate verification (insecure)")
 	fs.Bool("s3-keep-file", false, "Keep local file after successful upload")
 	return fs
 }
+
+func LoadFilesystemFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("filesystem-layout", "{prefix}", "Directory of the backups, supports {prefix}, {yyyy}, {mm} and {dd}")
+	fs.String("filesystem-file-mode", "", "Mode of the backup files in octal, for example 0640 (default is from the umask)")
+	fs.String("filesystem-dir-mode", "", "Mode of the created directories in octal, for example 0750 (default is from the umask)")
+	fs.Int("filesystem-uid", -1, "Owner of the backup files and created directories (-1 to keep the current user)")
+	fs.Int("filesystem-gid", -1, "Group of the backup files and created directories (-1 to keep the current group)")
+	return fs
+}
+
+func LoadSFTPFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("sftp-host", "", "SFTP host")
+	fs.String("sftp-port", "22", "SFTP port")
+	fs.String("sftp-user", "", "SFTP user")
+	fs.String("sftp-password", "", "SFTP password")
+	fs.String("sftp-password-file", "", "SFTP password file")
+	fs.String("sftp-private-key", "", "Path to the private key used to authenticate")
+	fs.String("sftp-private-key-passphrase", "", "Passphrase of the private key")
+	fs.String("sftp-private-key-passphrase-file", "", "Passphrase file of the private key")
+	fs.String("sftp-known-hosts-file", "", "Known hosts file (default is $HOME/.ssh/known_hosts)")
+	fs.Bool("sftp-insecure-ignore-host-key", false, "Skip host key verification (insecure)")
+	fs.String("sftp-remote-dir", "", "Remote directory where the backups are stored")
+	fs.Bool("sftp-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadAzureFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("azure-endpoint", "", "Azure blob service endpoint (default is https://<account>.blob.core.windows.net)")
+	fs.String("azure-account-name", "", "Azure storage account name")
+	fs.String("azure-account-key", "", "Azure storage account shared key")
+	fs.String("azure-account-key-file", "", "Azure storage account shared key file")
+	fs.String("azure-sas-token", "", "Azure SAS token, used when the account key is not set")
+	fs.String("azure-sas-token-file", "", "Azure SAS token file")
+	fs.String("azure-container", "", "Azure container")
+	fs.String("azure-prefix", "", "Azure prefix")
+	fs.Bool("azure-create-container", false, "Create the container if it doesn't exist")
+	fs.Int64("azure-block-size", 0, "Block size in MiB used on uploads/downloads (0 to use the SDK default)")
+	fs.Uint16("azure-concurrency", 0, "Number of blocks to transfer in parallel (0 to use the SDK default)")
+	fs.Bool("azure-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadGCSFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("gcs-endpoint", "", "GCS endpoint (needed for fake-gcs-server)")
+	fs.String("gcs-credentials-file", "", "Service account JSON file (default is to use the application default credentials)")
+	fs.Bool("gcs-no-auth", false, "Don't authenticate the requests (needed for fake-gcs-server)")
+	fs.String("gcs-bucket", "", "GCS bucket")
+	fs.String("gcs-prefix", "", "GCS prefix")
+	fs.Int("gcs-chunk-size", 0, "Chunk size in MiB used on resumable uploads (0 to use the library default)")
+	fs.Bool("gcs-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadWebDAVFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("webdav-url", "", "WebDAV url, for example https://cloud.example.com/remote.php/dav/files/user")
+	fs.String("webdav-user", "", "WebDAV user")
+	fs.String("webdav-user-file", "", "WebDAV user file")
+	fs.String("webdav-password", "", "WebDAV password")
+	fs.String("webdav-password-file", "", "WebDAV password file")
+	fs.String("webdav-prefix", "", "WebDAV prefix")
+	fs.Bool("webdav-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadFTPFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("ftp-host", "", "FTP host")
+	fs.String("ftp-port", "21", "FTP port")
+	fs.String("ftp-user", "", "FTP user")
+	fs.String("ftp-user-file", "", "FTP user file")
+	fs.String("ftp-password", "", "FTP password")
+	fs.String("ftp-password-file", "", "FTP password file")
+	fs.String("ftp-remote-dir", "", "Remote directory where the backups are stored")
+	fs.String("ftp-tls", "none", "FTP TLS mode (none, explicit, implicit)")
+	fs.String("ftp-tls-server-name", "", "Server name used to verify the certificate (default is the FTP host)")
+	fs.String("ftp-tls-ca-file", "", "CA bundle used to verify the server certificate")
+	fs.Bool("ftp-tls-insecure-skip-verify", false, "Skip the server certificate verification (insecure)")
+	fs.Bool("ftp-disable-epsv", false, "Use PASV instead of EPSV for passive mode")
+	fs.Duration("ftp-timeout", 30*time.Second, "FTP connection timeout")
+	fs.Bool("ftp-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadHTTPFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("http-url", "", "Base url where the backups are uploaded")
+	fs.StringSlice("http-headers", nil, "Extra headers sent on every request, in name: value format")
+	fs.String("http-user", "", "HTTP basic auth user")
+	fs.String("http-user-file", "", "HTTP basic auth user file")
+	fs.String("http-password", "", "HTTP basic auth password")
+	fs.String("http-password-file", "", "HTTP basic auth password file")
+	fs.String("http-bearer-token", "", "HTTP bearer token, has precedence over basic auth")
+	fs.String("http-bearer-token-file", "", "HTTP bearer token file")
+	fs.String("http-listing", "json", "How to list the backups (json, manifest)")
+	fs.String("http-list-url", "", "JSON listing url, {dir} is replaced with the backup directory (default is the directory url)")
+	fs.String("http-manifest-name", ".manifest.json", "Name of the manifest file kept on each directory")
+	fs.Bool("http-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadSwiftFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("swift-auth-url", "", "Swift/Keystone authentication url, for example https://keystone.example.com/v3")
+	fs.Int("swift-auth-version", 0, "Authentication version, 1, 2 or 3 (0 to detect it from the url)")
+	fs.String("swift-user", "", "Swift user name")
+	fs.String("swift-user-file", "", "Swift user name file")
+	fs.String("swift-api-key", "", "Swift API key or password")
+	fs.String("swift-api-key-file", "", "Swift API key or password file")
+	fs.String("swift-domain", "", "User domain name (v3 auth)")
+	fs.String("swift-tenant", "", "Tenant/project name")
+	fs.String("swift-tenant-domain", "", "Tenant/project domain name (v3 auth)")
+	fs.String("swift-application-credential-id", "", "Application credential ID (v3 auth)")
+	fs.String("swift-application-credential-secret", "", "Application credential secret (v3 auth)")
+	fs.String("swift-application-credential-secret-file", "", "Application credential secret file")
+	fs.String("swift-region", "", "Swift region")
+	fs.String("swift-container", "", "Swift container")
+	fs.String("swift-segment-container", "", "Container for the segments of large objects (default is <container>_segments)")
+	fs.String("swift-prefix", "", "Swift prefix")
+	fs.Int64("swift-segment-size", 0, "Segment size in MiB of large objects (0 to use the library default)")
+	fs.Int64("swift-large-object-threshold", 0, "Files bigger than this size in MiB are uploaded as large objects (0 to use the 5 GiB limit)")
+	fs.Bool("swift-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadRcloneFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("rclone-remote", "", "rclone remote and base path, for example dropbox:backups")
+	fs.String("rclone-config", "", "rclone config file (default is the rclone default location)")
+	fs.String("rclone-binary", "rclone", "Path to the rclone executable")
+	fs.StringSlice("rclone-args", nil, "Extra arguments passed to every rclone command")
+	fs.Bool("rclone-keep-file", false, "Keep local file after successful upload")
+	return fs
+}
+
+func LoadMirrorFlags(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.StringSlice("mirror-stores", nil, "Stores to send the backups to, restores are tried in the same order")
+	return fs
+}
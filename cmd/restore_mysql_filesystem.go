@@ -40,4 +40,6 @@ var restoreMysqlFilesystemCmd = &cobra.Command{
 
 func init() {
 	restoreMysqlCmd.AddCommand(restoreMysqlFilesystemCmd)
+	filesystemFs := LoadFilesystemFlags(restoreMysqlFilesystemCmd.Name())
+	restoreMysqlFilesystemCmd.Flags().AddFlagSet(filesystemFs)
 }
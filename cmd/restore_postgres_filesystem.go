@@ -40,4 +40,6 @@ var restorePostgresFilesystemCmd = &cobra.Command{
 
 func init() {
 	restorePostgresCmd.AddCommand(restorePostgresFilesystemCmd)
+	filesystemFs := LoadFilesystemFlags(restorePostgresFilesystemCmd.Name())
+	restorePostgresFilesystemCmd.Flags().AddFlagSet(filesystemFs)
 }
@@ -42,4 +42,6 @@ func init() {
 	restoreTarballCmd.AddCommand(restoreTarballFilesystemCmd)
 	tarballFs := LoadTarballFlags(restoreTarballFilesystemCmd.Name())
 	restoreTarballFilesystemCmd.Flags().AddFlagSet(tarballFs)
+	filesystemFs := LoadFilesystemFlags(restoreTarballFilesystemCmd.Name())
+	restoreTarballFilesystemCmd.Flags().AddFlagSet(filesystemFs)
 }
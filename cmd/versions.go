@@ -0,0 +1,45 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.megpoid.dev/go-s3-backup/commands"
+)
+
+var versionsCmd = &cobra.Command{
+	Use:     "versions",
+	Short:   "List every version of the S3 backups on versioned buckets",
+	GroupID: "command",
+	Args:    cobra.ExactArgs(0),
+	PreRun: func(cmd *cobra.Command, _ []string) {
+		cobra.CheckErr(viper.BindPFlags(cmd.Flags()))
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return commands.RunVersions()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionsCmd)
+
+	versionsFs := LoadVersionsFlags(versionsCmd.Name())
+	versionsCmd.Flags().AddFlagSet(versionsFs)
+	s3Fs := LoadS3Flags(versionsCmd.Name())
+	versionsCmd.Flags().AddFlagSet(s3Fs)
+}
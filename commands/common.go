@@ -18,12 +18,14 @@ package commands
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand"
 	"os"
 	"os/signal"
 	"path"
+	"sync"
 	"syscall"
 	"time"
 
@@ -31,6 +33,7 @@ import (
 	"github.com/spf13/viper"
 	"go.megpoid.dev/go-s3-backup/services"
 	"go.megpoid.dev/go-s3-backup/stores"
+	"go.megpoid.dev/go-s3-backup/version"
 )
 
 type task func() error
@@ -59,6 +62,24 @@ func GetStore(store string) stores.Storer {
 		config = newS3Config()
 	case "filesystem":
 		config = newFilesystemConfig()
+	case "sftp":
+		config = newSFTPConfig()
+	case "azure":
+		config = newAzureConfig()
+	case "gcs":
+		config = newGCSConfig()
+	case "webdav":
+		config = newWebDAVConfig()
+	case "ftp":
+		config = newFTPConfig()
+	case "http":
+		config = newHTTPConfig()
+	case "swift":
+		config = newSwiftConfig()
+	case "rclone":
+		config = newRcloneConfig()
+	case "mirror":
+		config = newMirrorConfig()
 	default:
 		slog.Error("Unsupported store", "store", store)
 		os.Exit(1)
@@ -88,40 +109,147 @@ func RunTask(command string, serviceName string, storeName string) error {
 }
 
 func backupTask(service services.Service, store stores.Storer) error {
+	// finish the uploads that failed on previous runs before creating new backups
+	if uploader, ok := store.(stores.PendingUploader); ok {
+		if err := uploader.ResumePendingUploads(); err != nil {
+			slog.Warn("Cannot resume pending uploads", "error", err)
+		}
+	}
+
 	results, err := service.Backup()
 	if err != nil {
 		return fmt.Errorf("service backup failed: %v", err)
 	}
 
+	// entries that share the retention are uploaded one after another by the same worker
+	type retentionKey struct{ dirPrefix, namePrefix string }
+
+	var groups [][]services.BackupResult
+	index := map[retentionKey]int{}
+
 	for _, result := range results.Entries {
-		slog.Debug("Backup saved", "basedir", result.DirPrefix, "path", result.Path)
-		filename := path.Base(result.Path)
-		if err = store.Store(result.Path, result.DirPrefix, filename); err != nil {
-			return fmt.Errorf("couldn't upload file to store: %v", err)
+		key := retentionKey{result.DirPrefix, result.NamePrefix}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
 		}
 
-		err = store.RemoveOlderBackups(result.DirPrefix, result.NamePrefix, viper.GetInt("max-backups"))
-		if err != nil {
-			return fmt.Errorf("couldn't remove old backups from store: %v", err)
-		}
+		groups[i] = append(groups[i], result)
+	}
+
+	workers := min(max(viper.GetInt("upload-concurrency"), 1), len(groups))
+	jobs := make(chan []services.BackupResult)
+
+	var mu, retention sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for group := range jobs {
+				for _, result := range group {
+					if err := uploadBackup(store, result, &retention); err != nil {
+						mu.Lock()
+						errs = append(errs, err)
+						mu.Unlock()
+					}
+				}
+			}
+		}()
+	}
+
+	for _, group := range groups {
+		jobs <- group
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// uploadBackup saves a backup on the store and removes the older ones with the same prefix. The
+// removal can list and delete across the whole store (store quota), so it runs while holding the
+// retention lock and never at the same time as the removal of other workers.
+func uploadBackup(store stores.Storer, result services.BackupResult, retention *sync.Mutex) error {
+	slog.Debug("Backup saved", "basedir", result.DirPrefix, "path", result.Path)
+	filename := path.Base(result.Path)
+
+	err := stores.StoreWithMetadata(store, result.Path, result.DirPrefix, filename, backupMetadata(result))
+	if err != nil {
+		return fmt.Errorf("couldn't upload file %s to store: %v", filename, err)
+	}
+
+	retention.Lock()
+	defer retention.Unlock()
+
+	err = store.RemoveOlderBackups(result.DirPrefix, result.NamePrefix, retentionPolicy())
+	if err != nil {
+		return fmt.Errorf("couldn't remove old backups of %s from store: %v", filename, err)
 	}
 
 	return nil
 }
 
-func restoreTask(service services.Service, store stores.Storer) error {
-	var err error
-	var filename string
+// retentionPolicy returns the rules used to remove the older backups
+func retentionPolicy() stores.RetentionPolicy {
+	return stores.RetentionPolicy{
+		Keep:         viper.GetInt("max-backups"),
+		Daily:        viper.GetInt("keep-daily"),
+		Weekly:       viper.GetInt("keep-weekly"),
+		Monthly:      viper.GetInt("keep-monthly"),
+		Yearly:       viper.GetInt("keep-yearly"),
+		MaxAge:       time.Duration(viper.GetInt("max-age-days")) * 24 * time.Hour,
+		MinCount:     viper.GetInt("min-backups"),
+		MaxSize:      viper.GetInt64("max-size") * 1024 * 1024,
+		MaxStoreSize: viper.GetInt64("max-store-size") * 1024 * 1024,
+	}
+}
+
+// backupMetadata returns the details of a backup that are saved by the stores
+func backupMetadata(result services.BackupResult) map[string]string {
+	host := result.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
 
+	return map[string]string{
+		"service":     result.Service,
+		"host":        host,
+		"database":    result.Database,
+		"schema":      result.Schema,
+		"user":        result.User,
+		"version":     version.Tag,
+		"compression": result.Compression,
+		"sha256":      result.Checksum,
+	}
+}
+
+// findBackup returns the configured backup file or the most recent one of the store
+func findBackup(store stores.Storer) (string, error) {
 	if key := viper.GetString("restore-file"); key != "" {
 		// restore directly from this file
-		filename = key
-	} else {
-		// find the latest file in the store
-		filename, err = store.FindLatestBackup("", viper.GetString("restore-prefix"))
-		if err != nil {
-			return fmt.Errorf("cannot find the latest backup: %v", err)
-		}
+		return key, nil
+	}
+
+	// find the latest file in the store
+	filename, err := store.FindLatestBackup("", viper.GetString("restore-prefix"))
+	if err != nil {
+		return "", fmt.Errorf("cannot find the latest backup: %v", err)
+	}
+
+	return filename, nil
+}
+
+func restoreTask(service services.Service, store stores.Storer) error {
+	filename, err := findBackup(store)
+	if err != nil {
+		return err
 	}
 
 	filepath, err := store.Retrieve(filename)
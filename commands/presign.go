@@ -0,0 +1,74 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// maxPresignExpiration is the longest lifetime allowed by S3 for a presigned url
+const maxPresignExpiration = 7 * 24 * time.Hour
+
+// presignExpiration returns the configured lifetime of the url, capped to the configured maximum
+func presignExpiration() (time.Duration, error) {
+	expires := viper.GetDuration("presign-expires")
+	limit := viper.GetDuration("presign-max-expires")
+
+	if expires <= 0 {
+		return 0, fmt.Errorf("the url expiration must be greater than zero")
+	}
+
+	if limit <= 0 || limit > maxPresignExpiration {
+		limit = maxPresignExpiration
+	}
+
+	if expires > limit {
+		slog.Warn("Url expiration is over the limit, capping it", "expires", expires, "limit", limit)
+		expires = limit
+	}
+
+	return expires, nil
+}
+
+// RunPresign prints a presigned url to download a backup from the S3 store
+func RunPresign() error {
+	expires, err := presignExpiration()
+	if err != nil {
+		return err
+	}
+
+	store := newS3Config()
+
+	filename, err := findBackup(store)
+	if err != nil {
+		return err
+	}
+
+	url, err := store.Presign(filename, expires)
+	if err != nil {
+		return fmt.Errorf("cannot presign backup %s: %v", filename, err)
+	}
+
+	slog.Debug("Presigned backup", "key", filename, "expires", expires)
+	fmt.Println(url)
+
+	return nil
+}
@@ -17,19 +17,67 @@ limitations under the License.
 package commands
 
 import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
 	"github.com/spf13/viper"
 	"go.megpoid.dev/go-s3-backup/stores"
 )
 
 func newS3Config() *stores.S3Config {
+	kmsContext := map[string]string{}
+	for _, pair := range getStringSlice("s3-sse-kms-context") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			slog.Error("Invalid SSE-KMS encryption context, expected key=value", "context", pair)
+			os.Exit(1)
+		}
+		kmsContext[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
 	return &stores.S3Config{
 		// S3 config
-		Endpoint:        viper.GetString("s3-endpoint"),
-		Region:          viper.GetString("s3-region"),
-		Bucket:          viper.GetString("s3-bucket"),
-		Prefix:          viper.GetString("s3-prefix"),
-		ForcePathStyle:  viper.GetBool("s3-force-path-style"),
-		KeepAfterUpload: viper.GetBool("s3-keep-file"),
+		Endpoint:              viper.GetString("s3-endpoint"),
+		Region:                viper.GetString("s3-region"),
+		Bucket:                viper.GetString("s3-bucket"),
+		Prefix:                viper.GetString("s3-prefix"),
+		ForcePathStyle:        viper.GetBool("s3-force-path-style"),
+		AccessKey:             fileOrString("s3-access-key"),
+		SecretKey:             fileOrString("s3-secret-key"),
+		SessionToken:          fileOrString("s3-session-token"),
+		Profile:               viper.GetString("s3-profile"),
+		SharedCredentialsFile: viper.GetString("s3-shared-credentials-file"),
+		RoleARN:               viper.GetString("s3-role-arn"),
+		RoleExternalID:        fileOrString("s3-role-external-id"),
+		RoleSessionName:       viper.GetString("s3-role-session-name"),
+		RoleDuration:          viper.GetDuration("s3-role-duration"),
+		WebIdentityTokenFile:  viper.GetString("s3-web-identity-token-file"),
+		STSEndpoint:           viper.GetString("s3-sts-endpoint"),
+		SSE:                   viper.GetString("s3-sse"),
+		SSEKMSKeyID:           viper.GetString("s3-sse-kms-key-id"),
+		SSEKMSContext:         kmsContext,
+		SSECustomerKey:        viper.GetString("s3-sse-customer-key-file"),
+		StorageClass:          viper.GetString("s3-storage-class"),
+		RestoreDays:           viper.GetInt64("s3-restore-days"),
+		RestoreTier:           viper.GetString("s3-restore-tier"),
+		RestoreTimeout:        viper.GetDuration("s3-restore-timeout"),
+		RestorePollInterval:   viper.GetDuration("s3-restore-poll-interval"),
+		ObjectLockMode:        viper.GetString("s3-object-lock-mode"),
+		ObjectLockDays:        viper.GetInt("s3-object-lock-days"),
+		Tags:                  getStringSlice("s3-tags"),
+		VersionID:             viper.GetString("s3-version-id"),
+		ResumableUpload:       viper.GetBool("s3-resumable-upload"),
+		PartSize:              viper.GetInt64("s3-part-size") * 1024 * 1024,
+		UploadRetries:         viper.GetInt("s3-upload-retries"),
+		StaleUploadAge:        viper.GetDuration("s3-stale-upload-age"),
+		CAFile:                viper.GetString("s3-ca-file"),
+		ClientCertFile:        viper.GetString("s3-client-cert-file"),
+		ClientKeyFile:         viper.GetString("s3-client-key-file"),
+		ProxyURL:              viper.GetString("s3-proxy"),
+		InsecureSkipVerify:    viper.GetBool("s3-insecure-skip-verify"),
+		KeepAfterUpload:       viper.GetBool("s3-keep-file"),
 		// default config
 		SaveDir: viper.GetString("save-dir"),
 	}
@@ -37,6 +85,206 @@ func newS3Config() *stores.S3Config {
 
 func newFilesystemConfig() *stores.FilesystemConfig {
 	return &stores.FilesystemConfig{
+		// default config
+		SaveDir:  viper.GetString("save-dir"),
+		Layout:   viper.GetString("filesystem-layout"),
+		FileMode: getFileMode("filesystem-file-mode"),
+		DirMode:  getFileMode("filesystem-dir-mode"),
+		UID:      getOwnerID("filesystem-uid"),
+		GID:      getOwnerID("filesystem-gid"),
+	}
+}
+
+// getOwnerID returns the configured user or group id, nil if unset
+func getOwnerID(key string) *int {
+	id := viper.GetInt(key)
+	if id < 0 {
+		return nil
+	}
+
+	return &id
+}
+
+// getFileMode parses an octal file mode, zero if unset
+func getFileMode(key string) os.FileMode {
+	value := viper.GetString(key)
+	if value == "" {
+		return 0
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		slog.Error("Invalid file mode, expected an octal number like 0640", "option", key, "value", value)
+		os.Exit(1)
+	}
+
+	return os.FileMode(mode)
+}
+
+func newSFTPConfig() *stores.SFTPConfig {
+	return &stores.SFTPConfig{
+		// SFTP config
+		Host:                  viper.GetString("sftp-host"),
+		Port:                  viper.GetString("sftp-port"),
+		User:                  viper.GetString("sftp-user"),
+		Password:              fileOrString("sftp-password"),
+		PrivateKey:            viper.GetString("sftp-private-key"),
+		PrivateKeyPassphrase:  fileOrString("sftp-private-key-passphrase"),
+		KnownHostsFile:        viper.GetString("sftp-known-hosts-file"),
+		InsecureIgnoreHostKey: viper.GetBool("sftp-insecure-ignore-host-key"),
+		RemoteDir:             viper.GetString("sftp-remote-dir"),
+		KeepAfterUpload:       viper.GetBool("sftp-keep-file"),
+		// default config
+		SaveDir: viper.GetString("save-dir"),
+	}
+}
+
+func newAzureConfig() *stores.AzureConfig {
+	return &stores.AzureConfig{
+		// Azure config
+		Endpoint:        viper.GetString("azure-endpoint"),
+		AccountName:     viper.GetString("azure-account-name"),
+		AccountKey:      fileOrString("azure-account-key"),
+		SASToken:        fileOrString("azure-sas-token"),
+		Container:       viper.GetString("azure-container"),
+		Prefix:          viper.GetString("azure-prefix"),
+		CreateContainer: viper.GetBool("azure-create-container"),
+		BlockSize:       viper.GetInt64("azure-block-size") * 1024 * 1024,
+		Concurrency:     viper.GetUint16("azure-concurrency"),
+		KeepAfterUpload: viper.GetBool("azure-keep-file"),
+		// default config
+		SaveDir: viper.GetString("save-dir"),
+	}
+}
+
+func newGCSConfig() *stores.GCSConfig {
+	return &stores.GCSConfig{
+		// GCS config
+		Endpoint:        viper.GetString("gcs-endpoint"),
+		CredentialsFile: viper.GetString("gcs-credentials-file"),
+		NoAuth:          viper.GetBool("gcs-no-auth"),
+		Bucket:          viper.GetString("gcs-bucket"),
+		Prefix:          viper.GetString("gcs-prefix"),
+		ChunkSize:       viper.GetInt("gcs-chunk-size") * 1024 * 1024,
+		KeepAfterUpload: viper.GetBool("gcs-keep-file"),
+		// default config
+		SaveDir: viper.GetString("save-dir"),
+	}
+}
+
+func newWebDAVConfig() *stores.WebDAVConfig {
+	return &stores.WebDAVConfig{
+		// WebDAV config
+		URL:             viper.GetString("webdav-url"),
+		User:            fileOrString("webdav-user"),
+		Password:        fileOrString("webdav-password"),
+		Prefix:          viper.GetString("webdav-prefix"),
+		KeepAfterUpload: viper.GetBool("webdav-keep-file"),
+		// default config
+		SaveDir: viper.GetString("save-dir"),
+	}
+}
+
+func newFTPConfig() *stores.FTPConfig {
+	return &stores.FTPConfig{
+		// FTP config
+		Host:                  viper.GetString("ftp-host"),
+		Port:                  viper.GetString("ftp-port"),
+		User:                  fileOrString("ftp-user"),
+		Password:              fileOrString("ftp-password"),
+		RemoteDir:             viper.GetString("ftp-remote-dir"),
+		TLSMode:               viper.GetString("ftp-tls"),
+		TLSServerName:         viper.GetString("ftp-tls-server-name"),
+		TLSCAFile:             viper.GetString("ftp-tls-ca-file"),
+		TLSInsecureSkipVerify: viper.GetBool("ftp-tls-insecure-skip-verify"),
+		DisableEPSV:           viper.GetBool("ftp-disable-epsv"),
+		Timeout:               viper.GetDuration("ftp-timeout"),
+		KeepAfterUpload:       viper.GetBool("ftp-keep-file"),
+		// default config
+		SaveDir: viper.GetString("save-dir"),
+	}
+}
+
+func newMirrorConfig() *stores.MirrorConfig {
+	names := getStringSlice("mirror-stores")
+	if len(names) == 0 {
+		slog.Error("No stores configured for the mirror")
+		os.Exit(1)
+	}
+
+	config := &stores.MirrorConfig{}
+	for _, name := range names {
+		if name == "mirror" {
+			slog.Error("A mirror cannot contain another mirror")
+			os.Exit(1)
+		}
+
+		config.Stores = append(config.Stores, GetStore(name))
+		config.Names = append(config.Names, name)
+	}
+
+	return config
+}
+
+func newHTTPConfig() *stores.HTTPConfig {
+	headers := map[string]string{}
+	for _, header := range getStringSlice("http-headers") {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			slog.Error("Invalid HTTP header, expected name: value", "header", header)
+			os.Exit(1)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return &stores.HTTPConfig{
+		// HTTP config
+		URL:             viper.GetString("http-url"),
+		Headers:         headers,
+		User:            fileOrString("http-user"),
+		Password:        fileOrString("http-password"),
+		BearerToken:     fileOrString("http-bearer-token"),
+		ListingMode:     viper.GetString("http-listing"),
+		ListURL:         viper.GetString("http-list-url"),
+		ManifestName:    viper.GetString("http-manifest-name"),
+		KeepAfterUpload: viper.GetBool("http-keep-file"),
+		// default config
+		SaveDir: viper.GetString("save-dir"),
+	}
+}
+
+func newSwiftConfig() *stores.SwiftConfig {
+	return &stores.SwiftConfig{
+		// Swift config
+		AuthURL:                 viper.GetString("swift-auth-url"),
+		AuthVersion:             viper.GetInt("swift-auth-version"),
+		User:                    fileOrString("swift-user"),
+		APIKey:                  fileOrString("swift-api-key"),
+		Domain:                  viper.GetString("swift-domain"),
+		Tenant:                  viper.GetString("swift-tenant"),
+		TenantDomain:            viper.GetString("swift-tenant-domain"),
+		ApplicationCredentialID: viper.GetString("swift-application-credential-id"),
+		ApplicationSecret:       fileOrString("swift-application-credential-secret"),
+		Region:                  viper.GetString("swift-region"),
+		Container:               viper.GetString("swift-container"),
+		SegmentContainer:        viper.GetString("swift-segment-container"),
+		Prefix:                  viper.GetString("swift-prefix"),
+		SegmentSize:             viper.GetInt64("swift-segment-size") * 1024 * 1024,
+		LargeObjectThreshold:    viper.GetInt64("swift-large-object-threshold") * 1024 * 1024,
+		KeepAfterUpload:         viper.GetBool("swift-keep-file"),
+		// default config
+		SaveDir: viper.GetString("save-dir"),
+	}
+}
+
+func newRcloneConfig() *stores.RcloneConfig {
+	return &stores.RcloneConfig{
+		// rclone config
+		Remote:          viper.GetString("rclone-remote"),
+		ConfigFile:      viper.GetString("rclone-config"),
+		Binary:          viper.GetString("rclone-binary"),
+		ExtraArgs:       getStringSlice("rclone-args"),
+		KeepAfterUpload: viper.GetBool("rclone-keep-file"),
 		// default config
 		SaveDir: viper.GetString("save-dir"),
 	}
@@ -0,0 +1,55 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RunVersions prints every version of the backups of the S3 store
+func RunVersions() error {
+	store := newS3Config()
+
+	versions, err := store.ListVersions(viper.GetString("restore-file"), viper.GetString("restore-prefix"))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KEY\tVERSION\tLAST MODIFIED\tSIZE\tSTATUS")
+
+	for _, v := range versions {
+		var status []string
+		if v.IsLatest {
+			status = append(status, "latest")
+		}
+		if v.DeleteMarker {
+			status = append(status, "deleted")
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
+			v.Key, v.VersionID, v.LastModified.Format(time.RFC3339), v.Size, strings.Join(status, ","))
+	}
+
+	return w.Flush()
+}
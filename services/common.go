@@ -18,7 +18,11 @@ package services
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
 	"os"
@@ -38,6 +42,15 @@ type BackupResult struct {
 	DirPrefix  string
 	NamePrefix string
 	Path       string
+	// details of the backup, the stores can save them as metadata
+	Service     string
+	Host        string
+	Database    string
+	Schema      string
+	User        string
+	Compression string
+	// Checksum is the hex encoded SHA-256 of the backup
+	Checksum string
 }
 
 // Service represents the methods to back up/restore a service
@@ -132,7 +145,7 @@ func (app *CmdConfig) CmdRun(name string, arg ...string) error {
 	readErr = <-doneRead
 
 	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("failed to wait for process: %v", err)
+		return fmt.Errorf("failed to wait for process: %w", err)
 	}
 
 	if readErr != nil {
@@ -146,6 +159,76 @@ func (app *CmdConfig) CmdRun(name string, arg ...string) error {
 	return nil
 }
 
+// compressionName returns the compression of the backups that are optionally written through gzip
+func compressionName(compressed bool) string {
+	if compressed {
+		return "gzip"
+	}
+
+	return "none"
+}
+
+// backupWriter writes a backup to a hidden temporary file that is renamed to its final name when
+// complete, so a crash never leaves a truncated file that looks like a valid backup. The checksum
+// is computed while writing so the stores don't need to read the backup again.
+type backupWriter struct {
+	filepath string
+	file     *os.File
+	hash     hash.Hash
+}
+
+// tempName returns the name used while a backup is being written
+func tempName(filepath string) string {
+	return path.Join(path.Dir(filepath), "."+path.Base(filepath)+".tmp")
+}
+
+func createBackup(filepath string) (*backupWriter, error) {
+	f, err := os.Create(tempName(filepath))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create file: %v", err)
+	}
+
+	return &backupWriter{filepath: filepath, file: f, hash: sha256.New()}, nil
+}
+
+func (b *backupWriter) Write(p []byte) (int, error) {
+	n, err := b.file.Write(p)
+	b.hash.Write(p[:n])
+
+	return n, err
+}
+
+// Checksum returns the hex encoded SHA-256 of the contents written so far
+func (b *backupWriter) Checksum() string {
+	return hex.EncodeToString(b.hash.Sum(nil))
+}
+
+// Commit flushes the backup and moves it to its final name
+func (b *backupWriter) Commit() error {
+	if err := b.file.Sync(); err != nil {
+		return fmt.Errorf("cannot flush file contents: %v", err)
+	}
+
+	if err := b.file.Close(); err != nil {
+		return fmt.Errorf("cannot close file: %v", err)
+	}
+
+	if err := os.Rename(b.file.Name(), b.filepath); err != nil {
+		return fmt.Errorf("cannot rename file: %v", err)
+	}
+
+	return nil
+}
+
+// Abort removes the temporary file of a failed backup, it does nothing after a commit
+func (b *backupWriter) Abort() {
+	_ = b.file.Close()
+
+	if err := os.Remove(b.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Cannot remove temporary file", "path", b.file.Name(), "error", err)
+	}
+}
+
 func generateFilename(dir, prefix string) string {
 	now := time.Now().Format("20060102150405")
 	return path.Join(dir, prefix+"-"+now)
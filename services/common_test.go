@@ -17,6 +17,8 @@ limitations under the License.
 package services
 
 import (
+	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -43,3 +45,50 @@ func TestCensor(t *testing.T) {
 	res = censorArg(long, "")
 	r.Equal(res, long)
 }
+
+func TestCompression(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("gzip", (&PostgresConfig{Compress: true}).compression())
+	r.Equal("none", (&PostgresConfig{}).compression())
+	r.Equal("custom", (&PostgresConfig{Custom: true, Compress: true, Database: "app"}).compression(),
+		"the custom format should not be labeled as gzip")
+	r.Equal("gzip", (&PostgresConfig{Custom: true, Compress: true}).compression(),
+		"pg_dumpall doesn't support the custom format")
+}
+
+func TestBackupWriter(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	filepath := path.Join(tmp, "test-20250101000000.sql")
+
+	out, err := createBackup(filepath)
+	r.NoError(err, "failed to create backup")
+	_, err = out.Write([]byte("test"))
+	r.NoError(err)
+	r.NoFileExists(filepath, "the backup should not have its final name while being written")
+
+	out.Abort()
+	entries, err := os.ReadDir(tmp)
+	r.NoError(err)
+	r.Empty(entries, "failed backups should be removed")
+
+	out, err = createBackup(filepath)
+	r.NoError(err, "failed to create backup")
+	_, err = out.Write([]byte("test"))
+	r.NoError(err)
+	r.NoError(out.Commit(), "failed to commit backup")
+	out.Abort()
+
+	r.Equal("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", out.Checksum(),
+		"the checksum should be computed while writing")
+
+	actual, err := os.ReadFile(filepath)
+	r.NoError(err, "the backup should be renamed when complete")
+	r.Equal([]byte("test"), actual)
+
+	entries, err = os.ReadDir(tmp)
+	r.NoError(err)
+	r.Len(entries, 1, "the temporary file should not be left behind")
+}
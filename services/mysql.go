@@ -130,11 +130,20 @@ func (m *MySQLConfig) Backup() (*BackupResults, error) {
 
 			m.Database = database
 			namePrefix := m.getNamePrefix()
-			filepath, err := m.backupDatabase("", namePrefix)
+			filepath, checksum, err := m.backupDatabase("", namePrefix)
 			if err != nil {
 				return nil, fmt.Errorf("failed to backup database %s, %w", database, err)
 			}
-			resultEntry := BackupResult{DirPrefix: namePrefix, NamePrefix: namePrefix, Path: filepath}
+			resultEntry := BackupResult{
+				DirPrefix:   namePrefix,
+				NamePrefix:  namePrefix,
+				Path:        filepath,
+				Service:     "mysql",
+				Host:        m.Host,
+				Database:    database,
+				Compression: compressionName(m.Compress),
+				Checksum:    checksum,
+			}
 			resultList = append(resultList, resultEntry)
 		}
 
@@ -142,19 +151,24 @@ func (m *MySQLConfig) Backup() (*BackupResults, error) {
 		return result, nil
 	default:
 		namePrefix := m.getNamePrefix()
-		filepath, err := m.backupDatabase("", namePrefix)
+		filepath, checksum, err := m.backupDatabase("", namePrefix)
 		if err != nil {
 			return nil, err
 		}
 
 		return &BackupResults{Entries: []BackupResult{{
-			NamePrefix: namePrefix,
-			Path:       filepath,
+			NamePrefix:  namePrefix,
+			Path:        filepath,
+			Service:     "mysql",
+			Host:        m.Host,
+			Database:    m.Database,
+			Compression: compressionName(m.Compress),
+			Checksum:    checksum,
 		}}}, nil
 	}
 }
 
-func (m *MySQLConfig) backupDatabase(basedir, namePrefix string) (string, error) {
+func (m *MySQLConfig) backupDatabase(basedir, namePrefix string) (string, string, error) {
 	savePath := path.Join(m.SaveDir, basedir)
 	filepath := generateFilename(savePath, namePrefix)
 	args := m.newBaseArgs(false)
@@ -167,7 +181,6 @@ func (m *MySQLConfig) backupDatabase(basedir, namePrefix string) (string, error)
 
 	if !m.Compress {
 		filepath += ".sql"
-		args = append(args, "-r", filepath)
 	} else {
 		filepath += ".sql.gz"
 	}
@@ -175,28 +188,39 @@ func (m *MySQLConfig) backupDatabase(basedir, namePrefix string) (string, error)
 	app := CmdConfig{CensorArg: "-p"}
 
 	if err := os.MkdirAll(m.SaveDir, 0o755); err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	if m.Compress {
-		f, err := os.Create(filepath)
-		if err != nil {
-			return "", fmt.Errorf("cannot create file: %v", err)
-		}
+	out, err := createBackup(filepath)
+	if err != nil {
+		return "", "", err
+	}
 
-		defer f.Close()
+	defer out.Abort()
 
-		writer := gzip.NewWriter(f)
-		defer writer.Close()
+	app.OutputFile = out
 
+	var writer *gzip.Writer
+	if m.Compress {
+		writer = gzip.NewWriter(out)
 		app.OutputFile = writer
 	}
 
-	if err := app.CmdRun(MysqlDumpApp, args...); err != nil {
-		return "", fmt.Errorf("couldn't execute %s, %v", MysqlDumpApp, err)
+	if err = app.CmdRun(MysqlDumpApp, args...); err != nil {
+		return "", "", fmt.Errorf("couldn't execute %s, %v", MysqlDumpApp, err)
+	}
+
+	if writer != nil {
+		if err = writer.Close(); err != nil {
+			return "", "", fmt.Errorf("cannot compress file: %v", err)
+		}
+	}
+
+	if err = out.Commit(); err != nil {
+		return "", "", err
 	}
 
-	return filepath, nil
+	return filepath, out.Checksum(), nil
 }
 
 // Restore takes a database dump and restores it
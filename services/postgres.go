@@ -115,14 +115,19 @@ func (p *PostgresConfig) Backup() (*BackupResults, error) {
 		return p.backupPerSchema()
 	default:
 		namePrefix := p.getNamePrefix()
-		filepath, err := p.backupDatabase("", namePrefix)
+		filepath, checksum, err := p.backupDatabase("", namePrefix)
 		if err != nil {
 			return nil, err
 		}
 
 		return &BackupResults{Entries: []BackupResult{{
-			NamePrefix: namePrefix,
-			Path:       filepath,
+			NamePrefix:  namePrefix,
+			Path:        filepath,
+			Service:     "postgres",
+			Host:        p.Host,
+			Database:    p.Database,
+			Compression: p.compression(),
+			Checksum:    checksum,
 		}}}, nil
 	}
 }
@@ -189,11 +194,21 @@ func (p *PostgresConfig) backupPerUser() (*BackupResults, error) {
 
 			p.Database = database
 			namePrefix := p.getNamePrefix()
-			filepath, err := p.backupDatabase(user, namePrefix)
+			filepath, checksum, err := p.backupDatabase(user, namePrefix)
 			if err != nil {
 				return nil, fmt.Errorf("failed to backup database %s, %w", database, err)
 			}
-			resultEntry := BackupResult{DirPrefix: user, NamePrefix: namePrefix, Path: filepath}
+			resultEntry := BackupResult{
+				DirPrefix:   user,
+				NamePrefix:  namePrefix,
+				Path:        filepath,
+				Service:     "postgres",
+				Host:        p.Host,
+				Database:    database,
+				User:        user,
+				Compression: p.compression(),
+				Checksum:    checksum,
+			}
 			resultList = append(resultList, resultEntry)
 		}
 	}
@@ -240,11 +255,21 @@ func (p *PostgresConfig) backupPerSchema() (*BackupResults, error) {
 
 		namePrefix := p.getNamePrefix() + "_" + schema
 		baseDir := path.Join(p.Database, schema)
-		filepath, err := p.backupDatabase(baseDir, namePrefix, schema)
+		filepath, checksum, err := p.backupDatabase(baseDir, namePrefix, schema)
 		if err != nil {
 			return nil, fmt.Errorf("failed to backup database schema %s, %w", schema, err)
 		}
-		resultEntry := BackupResult{DirPrefix: baseDir, NamePrefix: namePrefix, Path: filepath}
+		resultEntry := BackupResult{
+			DirPrefix:   baseDir,
+			NamePrefix:  namePrefix,
+			Path:        filepath,
+			Service:     "postgres",
+			Host:        p.Host,
+			Database:    p.Database,
+			Schema:      schema,
+			Compression: p.compression(),
+			Checksum:    checksum,
+		}
 		resultList = append(resultList, resultEntry)
 	}
 
@@ -252,6 +277,15 @@ func (p *PostgresConfig) backupPerSchema() (*BackupResults, error) {
 	return result, nil
 }
 
+// compression returns the compression of the dumps, the custom format of pg_dump is compressed by itself
+func (p *PostgresConfig) compression() string {
+	if p.Custom && p.Database != "" {
+		return "custom"
+	}
+
+	return compressionName(p.Compress)
+}
+
 func (p *PostgresConfig) getNamePrefix() string {
 	var prefix string
 	switch {
@@ -267,7 +301,7 @@ func (p *PostgresConfig) getNamePrefix() string {
 }
 
 // Backup generates a dump of the database and returns the path where is stored
-func (p *PostgresConfig) backupDatabase(basedir, namePrefix string, schemas ...string) (string, error) {
+func (p *PostgresConfig) backupDatabase(basedir, namePrefix string, schemas ...string) (string, string, error) {
 	savePath := path.Join(p.SaveDir, basedir)
 	filepath := generateFilename(savePath, namePrefix)
 	args := p.newBaseArgs()
@@ -293,11 +327,9 @@ func (p *PostgresConfig) backupDatabase(basedir, namePrefix string, schemas ...s
 	switch {
 	case p.Custom && p.Database != "":
 		filepath += ".dump"
-		args = append(args, "-f", filepath)
 		args = append(args, "-Fc")
 	case !p.Compress:
 		filepath += ".sql"
-		args = append(args, "-f", filepath)
 	default:
 		filepath += ".sql.gz"
 	}
@@ -305,28 +337,39 @@ func (p *PostgresConfig) backupDatabase(basedir, namePrefix string, schemas ...s
 	app := p.newPostgresCmd()
 
 	if err := os.MkdirAll(savePath, 0o755); err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	if p.Compress && !p.Custom {
-		f, err := os.Create(filepath)
-		if err != nil {
-			return "", fmt.Errorf("cannot create file: %v", err)
-		}
+	out, err := createBackup(filepath)
+	if err != nil {
+		return "", "", err
+	}
 
-		defer f.Close()
+	defer out.Abort()
 
-		writer := gzip.NewWriter(f)
-		defer writer.Close()
+	app.OutputFile = out
 
+	var writer *gzip.Writer
+	if p.Compress && !p.Custom {
+		writer = gzip.NewWriter(out)
 		app.OutputFile = writer
 	}
 
-	if err := app.CmdRun(appPath, args...); err != nil {
-		return "", fmt.Errorf("couldn't execute %s, %v", appPath, err)
+	if err = app.CmdRun(appPath, args...); err != nil {
+		return "", "", fmt.Errorf("couldn't execute %s, %v", appPath, err)
+	}
+
+	if writer != nil {
+		if err = writer.Close(); err != nil {
+			return "", "", fmt.Errorf("cannot compress file: %v", err)
+		}
+	}
+
+	if err = out.Commit(); err != nil {
+		return "", "", err
 	}
 
-	return filepath, nil
+	return filepath, out.Checksum(), nil
 }
 
 // Restore takes a database dump and restores it
@@ -42,14 +42,17 @@ type TarballConfig struct {
 func (f *TarballConfig) Backup() (*BackupResults, error) {
 	namePrefix := f.getNamePrefix("")
 	if !f.BackupPerDir {
-		filepath, err := f.backupFile("", namePrefix)
+		filepath, checksum, err := f.backupFile("", namePrefix)
 		if err != nil {
 			return nil, err
 		}
 
 		return &BackupResults{Entries: []BackupResult{{
-			NamePrefix: namePrefix,
-			Path:       filepath,
+			NamePrefix:  namePrefix,
+			Path:        filepath,
+			Service:     "tarball",
+			Compression: compressionName(f.Compress),
+			Checksum:    checksum,
 		}}}, nil
 	}
 
@@ -89,15 +92,18 @@ func (f *TarballConfig) Backup() (*BackupResults, error) {
 			}
 		}
 
-		filepath, err := f.backupFile(file.Name(), namePrefix)
+		filepath, checksum, err := f.backupFile(file.Name(), namePrefix)
 		if err != nil {
 			return nil, err
 		}
 
 		resultList = append(resultList, BackupResult{
-			DirPrefix:  file.Name(),
-			NamePrefix: namePrefix,
-			Path:       filepath,
+			DirPrefix:   file.Name(),
+			NamePrefix:  namePrefix,
+			Path:        filepath,
+			Service:     "tarball",
+			Compression: compressionName(f.Compress),
+			Checksum:    checksum,
 		})
 	}
 
@@ -120,7 +126,7 @@ func (f *TarballConfig) getNamePrefix(basedir string) string {
 	return name
 }
 
-func (f *TarballConfig) backupFile(basedir, namePrefix string) (string, error) {
+func (f *TarballConfig) backupFile(basedir, namePrefix string) (string, string, error) {
 	destPath := path.Join(f.SaveDir, basedir)
 	filePath := generateFilename(destPath, namePrefix) + ".tar"
 
@@ -132,7 +138,7 @@ func (f *TarballConfig) backupFile(basedir, namePrefix string) (string, error) {
 	}
 
 	if err := os.MkdirAll(destPath, 0o755); err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	srcPath := path.Join(f.Path, basedir)
@@ -149,23 +155,27 @@ func (f *TarballConfig) backupFile(basedir, namePrefix string) (string, error) {
 		srcPath + string(os.PathSeparator): basePath,
 	})
 	if err != nil {
-		return "", fmt.Errorf("cannot prepare tarball files on %s, %v", filePath, err)
+		return "", "", fmt.Errorf("cannot prepare tarball files on %s, %v", filePath, err)
 	}
 
 	cleanFilePath := filepath.Clean(filePath)
 
-	out, err := os.Create(cleanFilePath)
+	out, err := createBackup(cleanFilePath)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	defer out.Close()
+	defer out.Abort()
 
 	err = format.Archive(ctx, out, files)
 	if err != nil {
-		return "", fmt.Errorf("cannot create tarball on %s, %v", filePath, err)
+		return "", "", fmt.Errorf("cannot create tarball on %s, %v", filePath, err)
 	}
 
-	return cleanFilePath, nil
+	if err = out.Commit(); err != nil {
+		return "", "", err
+	}
+
+	return cleanFilePath, out.Checksum(), nil
 }
 
 // Restore extracts a tarball to the specified directory
@@ -0,0 +1,262 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// AzureConfig has the config options for the Azure Blob Storage service
+type AzureConfig struct {
+	Endpoint        string
+	AccountName     string
+	AccountKey      string
+	SASToken        string
+	Container       string
+	Prefix          string
+	CreateContainer bool
+	BlockSize       int64
+	Concurrency     uint16
+	KeepAfterUpload bool
+	SaveDir         string
+	retrievedFile   string
+}
+
+func (a *AzureConfig) serviceURL() string {
+	if a.Endpoint != "" {
+		return strings.TrimSuffix(a.Endpoint, "/") + "/"
+	}
+
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", a.AccountName)
+}
+
+func (a *AzureConfig) newClient() (*azblob.Client, error) {
+	if a.AccountKey != "" {
+		cred, err := azblob.NewSharedKeyCredential(a.AccountName, a.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure shared key credential, %v", err)
+		}
+
+		client, err := azblob.NewClientWithSharedKeyCredential(a.serviceURL(), cred, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create Azure client, %v", err)
+		}
+
+		return client, nil
+	}
+
+	if a.SASToken != "" {
+		client, err := azblob.NewClientWithNoCredential(a.serviceURL()+"?"+strings.TrimPrefix(a.SASToken, "?"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create Azure client, %v", err)
+		}
+
+		return client, nil
+	}
+
+	return nil, fmt.Errorf("no Azure credentials configured, set an account key or a SAS token")
+}
+
+// Store saves a file to a remote Azure Blob Storage container
+func (a *AzureConfig) Store(filepath, prefix, filename string) error {
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if a.CreateContainer {
+		_, err = client.CreateContainer(ctx, a.Container, nil)
+		if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+			return fmt.Errorf("cannot create container %s, %v", a.Container, err)
+		}
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer func(f *os.File) {
+		closeErr := f.Close()
+		if closeErr != nil {
+			slog.Error("Cannot close file", "path", filepath, "error", closeErr)
+		}
+	}(f)
+
+	if !a.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err = os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	key := path.Clean(path.Join(a.Prefix, prefix, filename))
+
+	// Upload the file as a block blob, split in blocks for large files.
+	_, err = client.UploadFile(ctx, a.Container, key, f, &azblob.UploadFileOptions{
+		BlockSize:   a.BlockSize,
+		Concurrency: a.Concurrency,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	slog.Debug("File uploaded", "container", a.Container, "key", key)
+
+	return nil
+}
+
+func (a *AzureConfig) getFileListing(basedir, namePrefix string, client *azblob.Client) ([]string, error) {
+	var files []string
+	re := generatePattern(namePrefix)
+
+	// make sure that the prefix ends with "/"
+	prefix := path.Clean(path.Join(a.Prefix, basedir)) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	pager := client.NewListBlobsFlatPager(a.Container, &azblob.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			// ignore files not created by this program
+			if re.MatchString(path.Base(*item.Name)) {
+				files = append(files, *item.Name)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the Azure container and deletes the old ones
+func (a *AzureConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	files, err := a.getFileListing(basedir, namePrefix, client)
+	if err != nil {
+		return fmt.Errorf("couldn't list Azure blobs, %v", err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := 0
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			slog.Debug("Marked to delete", "container", a.Container, "file", file)
+			if _, err = client.DeleteBlob(context.Background(), a.Container, file, nil); err != nil {
+				slog.Error("Failed to remove blob", "name", file, "error", err)
+			} else {
+				deleted++
+			}
+		}
+
+		slog.Debug("Deleted objects from Azure", "count", deleted)
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the Azure container
+func (a *AzureConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	client, err := a.newClient()
+	if err != nil {
+		return "", err
+	}
+
+	files, err := a.getFileListing(basedir, namePrefix, client)
+	if err != nil {
+		return "", fmt.Errorf("couldn't list Azure blobs, %v", err)
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on azure://%s/%s", a.Container, a.Prefix)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads an Azure blob to the local filesystem
+func (a *AzureConfig) Retrieve(key string) (string, error) {
+	client, err := a.newClient()
+	if err != nil {
+		return "", err
+	}
+
+	filepath := path.Join(a.SaveDir, path.Base(key))
+	f, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer f.Close()
+
+	_, err = client.DownloadFile(context.Background(), a.Container, key, f, &azblob.DownloadFileOptions{
+		BlockSize:   a.BlockSize,
+		Concurrency: a.Concurrency,
+	})
+	if err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download Azure blob, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	a.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (a *AzureConfig) Close() {
+	if a.retrievedFile != "" {
+		if err := os.Remove(a.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", a.retrievedFile, "error", err)
+		}
+
+		a.retrievedFile = ""
+	}
+}
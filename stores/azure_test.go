@@ -0,0 +1,141 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// well-known development account of Azurite
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// azuriteEndpoint returns the blob endpoint of Azurite, the test is skipped if it isn't running.
+// Start it with `docker compose up azurite` or set AZURITE_ENDPOINT.
+func azuriteEndpoint(t *testing.T) string {
+	endpoint := os.Getenv("AZURITE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:10000/" + azuriteAccountName
+	}
+
+	u, err := url.Parse(endpoint)
+	require.NoError(t, err, "invalid Azurite endpoint")
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Skipf("Azurite not available on %s", u.Host)
+	}
+	_ = conn.Close()
+
+	return endpoint
+}
+
+func TestAzureServiceURL(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("https://account.blob.core.windows.net/", (&AzureConfig{AccountName: "account"}).serviceURL())
+	r.Equal("http://127.0.0.1:10000/devstoreaccount1/", (&AzureConfig{Endpoint: "http://127.0.0.1:10000/devstoreaccount1"}).serviceURL())
+
+	_, err := (&AzureConfig{AccountName: "account"}).newClient()
+	r.ErrorContains(err, "no Azure credentials", "a credential should be required")
+}
+
+func TestAzureStoreRetrieve(t *testing.T) {
+	r := require.New(t)
+	endpoint := azuriteEndpoint(t)
+	tmp := t.TempDir()
+
+	store := &AzureConfig{
+		Endpoint:        endpoint,
+		AccountName:     azuriteAccountName,
+		AccountKey:      azuriteAccountKey,
+		Container:       "test-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		Prefix:          "backups",
+		CreateContainer: true,
+		SaveDir:         tmp,
+	}
+
+	client, err := store.newClient()
+	r.NoError(err, "failed to create client")
+	t.Cleanup(func() {
+		_, _ = client.DeleteContainer(context.Background(), store.Container, nil)
+	})
+
+	// the container doesn't exist until the first upload
+	_, err = store.FindLatestBackup("db", "test")
+	r.Error(err, "a missing container should be reported")
+
+	names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "other-20250103000000.sql"}
+	for _, name := range names {
+		src := path.Join(tmp, name)
+		r.NoError(os.WriteFile(src, []byte(name), 0o600), "failed to create backup file")
+		r.NoError(store.Store(src, "db", name), "failed to store file")
+		r.NoFileExists(src, "source file should be removed after upload")
+	}
+
+	_, err = client.UploadBuffer(context.Background(), store.Container, "backups/db/notes.txt", []byte("notes"), nil)
+	r.NoError(err, "failed to upload unrelated blob")
+
+	r.NoError(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 1}))
+
+	files, err := store.getFileListing("db", "", client)
+	r.NoError(err)
+	r.Empty(files, "no backups should match an empty prefix")
+
+	for prefix, expected := range map[string][]string{
+		"test":  {"backups/db/test-20250102000000.sql"},
+		"other": {"backups/db/other-20250103000000.sql"},
+	} {
+		files, err = store.getFileListing("db", prefix, client)
+		r.NoError(err)
+		r.Equal(expected, files, "the retention should only apply to the same name prefix")
+	}
+
+	_, err = client.DownloadBuffer(context.Background(), store.Container, "backups/db/notes.txt", make([]byte, 5), nil)
+	r.NoError(err, "unrelated blobs should be kept")
+
+	latest, err := store.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal("backups/db/test-20250102000000.sql", latest)
+
+	local, err := store.Retrieve(latest)
+	r.NoError(err, "failed to retrieve backup")
+	actual, err := os.ReadFile(local)
+	r.NoError(err, "failed to read retrieved file")
+	r.Equal("test-20250102000000.sql", string(actual))
+
+	store.Close()
+	r.NoFileExists(local, "retrieved file should be removed on close")
+
+	_, err = store.Retrieve("backups/db/test-20250101000000.sql")
+	r.Error(err, "removed backups cannot be retrieved")
+
+	store.AccountKey = "aW52YWxpZA=="
+	_, err = store.FindLatestBackup("db", "test")
+	r.Error(err, "invalid credentials should be reported")
+}
@@ -17,7 +17,13 @@ limitations under the License.
 package stores
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"regexp"
 )
 
@@ -25,11 +31,56 @@ import (
 type Storer interface {
 	Store(filepath, prefix, filename string) error
 	Retrieve(s3path string) (string, error)
-	RemoveOlderBackups(basedir, namePrefix string, keep int) error
+	RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error
 	FindLatestBackup(basedir, namePrefix string) (string, error)
 	Close()
 }
 
+// MetadataStorer is implemented by the stores that can save details of the backup along with the file
+type MetadataStorer interface {
+	// StoreWithMetadata saves the file like Store along with the details of the backup
+	StoreWithMetadata(filepath, prefix, filename string, metadata map[string]string) error
+}
+
+// PendingUploader is implemented by the stores that keep the files of failed uploads to finish them later
+type PendingUploader interface {
+	// ResumePendingUploads finishes the uploads that failed on previous runs
+	ResumePendingUploads() error
+}
+
+// StoreWithMetadata saves the file with its details on the stores that support them
+func StoreWithMetadata(store Storer, filepath, prefix, filename string, metadata map[string]string) error {
+	if storer, ok := store.(MetadataStorer); ok && metadata != nil {
+		return storer.StoreWithMetadata(filepath, prefix, filename, metadata)
+	}
+
+	return store.Store(filepath, prefix, filename)
+}
+
 func generatePattern(prefix string) *regexp.Regexp {
 	return regexp.MustCompile(fmt.Sprintf("^%s-[[:digit:]]{14}\\.[[:alnum:].]+$", regexp.QuoteMeta(prefix)))
 }
+
+// fileChecksum returns the hex encoded SHA-256 of a file
+func fileChecksum(filepath string) (string, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return "", fmt.Errorf("cannot open file %s, %v", filepath, err)
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("cannot compute checksum of %s, %v", filepath, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// removePartialDownload removes the file of a failed download, Close only removes the retrieved files
+func removePartialDownload(filepath string) {
+	if err := os.Remove(filepath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Cannot remove file", "path", filepath, "error", err)
+	}
+}
@@ -17,35 +17,196 @@ limitations under the License.
 package stores
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 // FilesystemConfig has the config options for the FilesystemConfig service
 type FilesystemConfig struct {
-	SaveDir string
+	SaveDir  string
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	UID      *int
+	GID      *int
+	Layout   string
 }
 
-// Store moves/copies a file to another directory
+// defaultLayout keeps the backups on a directory per prefix
+const defaultLayout = "{prefix}"
+
+func (f *FilesystemConfig) layout() string {
+	if f.Layout == "" {
+		return defaultLayout
+	}
+
+	return f.Layout
+}
+
+// backupDir returns the directory of a backup, following the layout template
+func (f *FilesystemConfig) backupDir(prefix, filename string) string {
+	t, ok := backupTime(filename)
+	if !ok {
+		t = time.Now()
+	}
+
+	replacer := strings.NewReplacer(
+		"{prefix}", prefix,
+		"{yyyy}", t.Format("2006"),
+		"{mm}", t.Format("01"),
+		"{dd}", t.Format("02"),
+	)
+
+	return path.Clean(path.Join(f.SaveDir, replacer.Replace(f.layout())))
+}
+
+// globPattern returns a pattern that matches every file of the prefix on the layout
+func (f *FilesystemConfig) globPattern(prefix string) string {
+	replacer := strings.NewReplacer(
+		"{prefix}", escapeGlob(prefix),
+		"{yyyy}", "[0-9][0-9][0-9][0-9]",
+		"{mm}", "[0-9][0-9]",
+		"{dd}", "[0-9][0-9]",
+	)
+
+	return path.Join(escapeGlob(f.SaveDir), replacer.Replace(f.layout()), "*")
+}
+
+func escapeGlob(name string) string {
+	return strings.NewReplacer("\\", "\\\\", "*", "\\*", "?", "\\?", "[", "\\[").Replace(name)
+}
+
+// setPermissions changes the mode and owner of a file or directory, if configured
+func (f *FilesystemConfig) setPermissions(name string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(name, mode); err != nil {
+			return fmt.Errorf("cannot change mode of %s, %v", name, err)
+		}
+	}
+
+	// nil keeps the current value, 0 is root
+	if f.UID != nil || f.GID != nil {
+		uid, gid := -1, -1
+		if f.UID != nil {
+			uid = *f.UID
+		}
+		if f.GID != nil {
+			gid = *f.GID
+		}
+
+		if err := os.Chown(name, uid, gid); err != nil {
+			return fmt.Errorf("cannot change owner of %s, %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// mkdirAll creates the missing directories of the layout with the configured mode and owner
+func (f *FilesystemConfig) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+
+	parent := path.Dir(dir)
+	if parent != dir {
+		if err := f.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+		return fmt.Errorf("cannot create directory %s, %v", dir, err)
+	}
+
+	if err := f.setPermissions(dir, f.DirMode); err != nil {
+		return err
+	}
+
+	return syncDir(parent)
+}
+
+// syncDir flushes the entries of a directory so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("cannot open directory %s, %v", dir, err)
+	}
+
+	defer d.Close()
+
+	if err = d.Sync(); err != nil {
+		return fmt.Errorf("cannot flush directory %s, %v", dir, err)
+	}
+
+	return nil
+}
+
+// syncFile flushes the contents of a file to disk
+func syncFile(filepath string) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("cannot open file %s, %v", filepath, err)
+	}
+
+	defer f.Close()
+
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("cannot flush file contents, %v", err)
+	}
+
+	return nil
+}
+
+// tempName returns the name used while a backup is being written. It is hidden and doesn't
+// match the backup pattern, so it is never picked as the latest backup or counted by the retention.
+func tempName(dest string) string {
+	return path.Join(path.Dir(dest), "."+path.Base(dest)+".tmp")
+}
+
+// Store moves/copies a file to another directory. The file is written to a temporary name and
+// renamed when complete so a crash never leaves a truncated backup with the final name.
 func (f *FilesystemConfig) Store(src, prefix, filename string) error {
-	dest := path.Clean(path.Join(f.SaveDir, prefix, filename))
+	dir := f.backupDir(prefix, filename)
+	dest := path.Join(dir, filename)
 
 	if src == dest {
 		slog.Debug("Using the same path as source and destination, do nothing")
-		return nil
+		return f.setPermissions(dest, f.FileMode)
 	}
 
-	err := os.Rename(dest, src)
-	if err != nil {
-		slog.Warn("Cannot rename file, trying to copy instead", "source", src, "destination", dest)
-	} else {
-		return nil
+	if err := f.mkdirAll(dir); err != nil {
+		return err
 	}
 
-	removeSourceFile := false
+	// renames are atomic but only work on the same filesystem
+	if err := syncFile(src); err != nil {
+		return err
+	}
+
+	if err := f.setPermissions(src, f.FileMode); err != nil {
+		return err
+	}
+
+	err := os.Rename(src, dest)
+	if err == nil {
+		return syncDir(dir)
+	}
+
+	slog.Warn("Cannot rename file, trying to copy instead", "source", src, "destination", dest, "error", err)
 
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -56,24 +217,26 @@ func (f *FilesystemConfig) Store(src, prefix, filename string) error {
 		if err := srcFile.Close(); err != nil {
 			slog.Warn("Cannot close source file", "name", src)
 		}
-
-		// if there aren't any errors on the file copy then delete the source file
-		if removeSourceFile {
-			if err = os.Remove(src); err != nil {
-				slog.Warn("Cannot remove source file", "name", src)
-			}
-		}
 	}()
 
-	destFile, err := os.Create(dest)
+	tmp := tempName(dest)
+	destFile, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
 	if err != nil {
-		return fmt.Errorf("cannot create destination file %s, %v", dest, err)
+		return fmt.Errorf("cannot create destination file %s, %v", tmp, err)
 	}
 
-	defer destFile.Close()
+	completed := false
+	defer func() {
+		// don't leave partial copies behind
+		if !completed {
+			_ = destFile.Close()
+			if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Cannot remove temporary file", "name", tmp)
+			}
+		}
+	}()
 
-	_, err = io.Copy(destFile, srcFile) // check first var for number of bytes copied
-	if err != nil {
+	if _, err = io.Copy(destFile, srcFile); err != nil {
 		return fmt.Errorf("error while copying file, %v", err)
 	}
 
@@ -81,34 +244,142 @@ func (f *FilesystemConfig) Store(src, prefix, filename string) error {
 		return fmt.Errorf("cannot flush file contents, %v", err)
 	}
 
-	removeSourceFile = true
+	if err = destFile.Close(); err != nil {
+		return fmt.Errorf("cannot close destination file %s, %v", tmp, err)
+	}
+
+	if err = f.setPermissions(tmp, f.FileMode); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("cannot rename %s to %s, %v", tmp, dest, err)
+	}
+
+	completed = true
+
+	if err = syncDir(dir); err != nil {
+		return err
+	}
+
+	// the copy is complete, the source file isn't needed anymore
+	if err = os.Remove(src); err != nil {
+		slog.Warn("Cannot remove source file", "name", src)
+	}
 
 	return nil
 }
 
-func (f *FilesystemConfig) getFileListing(basedir, namePrefix string) ([]string, error) {
-	fullBasedir := path.Join(f.SaveDir, basedir)
-	files, err := os.ReadDir(fullBasedir)
+// getFileListing returns the backups of the prefix sorted from oldest to newest, the paths are
+// relative to the save directory
+func (f *FilesystemConfig) getFileListing(basedir, namePrefix string) ([]backupFile, error) {
+	matches, err := filepath.Glob(f.globPattern(basedir))
 	if err != nil {
-		return nil, fmt.Errorf("cannot list contents of directory %s, %v", f.SaveDir, err)
+		return nil, fmt.Errorf("invalid filesystem layout %s, %v", f.layout(), err)
 	}
+
 	re := generatePattern(namePrefix)
 
-	var filenames []string
-	for _, f := range files {
-		if !f.IsDir() {
-			// ignore files not created by this program
-			if re.MatchString(f.Name()) {
-				filenames = append(filenames, path.Join(fullBasedir, f.Name()))
-			}
+	var filenames []backupFile
+	for _, match := range matches {
+		// ignore files not created by this program
+		if !re.MatchString(path.Base(match)) {
+			continue
+		}
+
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() {
+			continue
 		}
+
+		rel, err := filepath.Rel(f.SaveDir, match)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get path of %s, %v", match, err)
+		}
+
+		filenames = append(filenames, backupFile{name: filepath.ToSlash(rel), modTime: info.ModTime(), size: info.Size()})
 	}
 
+	// the names have the timestamp, the directories of the layout may not sort in order
+	sort.SliceStable(filenames, func(i, j int) bool {
+		return path.Base(filenames[i].name) < path.Base(filenames[j].name)
+	})
+
 	return filenames, nil
 }
 
+// removeEmptyDirs removes the directories of the layout that were left empty, up to the prefix directory
+func (f *FilesystemConfig) removeEmptyDirs(dir, basedir string) {
+	root := path.Clean(path.Join(f.SaveDir, basedir))
+
+	for dir != root && strings.HasPrefix(dir, root+"/") {
+		// fails if the directory isn't empty
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+
+		dir = path.Dir(dir)
+	}
+}
+
+// listAllBackups returns the backups of every prefix of the store, the paths are relative to the save directory
+func (f *FilesystemConfig) listAllBackups() ([]backupFile, error) {
+	var files []backupFile
+
+	err := filepath.WalkDir(f.SaveDir, func(name string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// ignore files not created by this program
+		if entry.IsDir() || !entry.Type().IsRegular() || !anyBackupPattern.MatchString(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.SaveDir, name)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, backupFile{name: filepath.ToSlash(rel), modTime: info.ModTime(), size: info.Size()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list contents of directory %s, %v", f.SaveDir, err)
+	}
+
+	return files, nil
+}
+
+// removeFiles deletes the backups and the directories of the layout that were left empty
+func (f *FilesystemConfig) removeFiles(files []string, basedir string) {
+	if len(files) == 0 {
+		return
+	}
+
+	deleted := 0
+
+	for _, file := range files {
+		fullpath := path.Join(f.SaveDir, file)
+		if err := os.Remove(fullpath); err != nil {
+			slog.Error("Failed to remove file", "name", fullpath)
+		} else {
+			deleted++
+			f.removeEmptyDirs(path.Dir(fullpath), basedir)
+		}
+	}
+
+	slog.Debug("Deleted objects from filesystem", "count", deleted, "path", path.Join(f.SaveDir, basedir))
+}
+
 // RemoveOlderBackups keeps the most recent backups of a directory and deletes the old ones
-func (f *FilesystemConfig) RemoveOlderBackups(basedir, namePrefix string, keep int) error {
+func (f *FilesystemConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
 	filePaths, err := f.getFileListing(basedir, namePrefix)
 	if err != nil {
 		return err
@@ -118,23 +389,20 @@ func (f *FilesystemConfig) RemoveOlderBackups(basedir, namePrefix string, keep i
 		return nil
 	}
 
-	count := len(filePaths) - keep
-	deleted := 0
+	f.removeFiles(policy.expiredFiles(filePaths, time.Now()), basedir)
 
-	if count > 0 {
-		for _, file := range filePaths[:count] {
-			fullpath := path.Clean(file)
-			err = os.Remove(fullpath)
-			if err != nil {
-				slog.Error("Failed to remove file", "name", fullpath)
-			} else {
-				deleted++
-			}
-		}
+	if policy.MaxStoreSize <= 0 {
+		return nil
+	}
 
-		slog.Debug("Deleted objects from filesystem", "count", deleted, "path", path.Join(f.SaveDir, basedir))
+	// the whole store is listed again to apply its quota
+	files, err := f.listAllBackups()
+	if err != nil {
+		return err
 	}
 
+	f.removeFiles(policy.expiredByStoreSize(files), "")
+
 	return nil
 }
 
@@ -149,7 +417,7 @@ func (f *FilesystemConfig) FindLatestBackup(basedir, namePrefix string) (string,
 		return "", fmt.Errorf("cannot find a recent backup on %s", f.SaveDir)
 	}
 
-	return files[len(files)-1], nil
+	return files[len(files)-1].name, nil
 }
 
 // Retrieve returns the path of the requested file
@@ -19,6 +19,7 @@ package stores
 import (
 	"os"
 	"path"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -47,3 +48,141 @@ func TestStoreRestore(t *testing.T) {
 	err = fs.Store(filepath, "", "test.txt")
 	r.NoError(err, "failed to store file")
 }
+
+func TestStoreAtomic(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+	r.NoError(os.Mkdir(path.Join(tmp, "store"), 0o755), "failed to create store directory")
+
+	fs := FilesystemConfig{SaveDir: path.Join(tmp, "store")}
+	r.NoError(fs.Store(src, "", path.Base(src)), "failed to store file")
+	r.NoFileExists(src, "source file should be moved")
+
+	actual, err := os.ReadFile(path.Join(tmp, "store", path.Base(src)))
+	r.NoError(err, "file should be stored")
+	r.Equal([]byte("test"), actual)
+
+	// a backup that was being written when the process died
+	partial := tempName(path.Join(tmp, "store", "test-20250102000000.sql"))
+	r.NoError(os.WriteFile(partial, []byte("te"), 0o600), "failed to create partial file")
+
+	latest, err := fs.FindLatestBackup("", "test")
+	r.NoError(err)
+	r.Equal(path.Base(src), latest, "partial files should be ignored")
+
+	// the destination can't be replaced, the copy must be cleaned up
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+	r.NoError(os.Mkdir(path.Join(tmp, "store", "test-20250103000000.sql"), 0o755))
+
+	err = fs.Store(src, "", "test-20250103000000.sql")
+	r.Error(err, "store should fail")
+	r.NoFileExists(tempName(path.Join(tmp, "store", "test-20250103000000.sql")), "partial copy should be removed")
+	r.FileExists(src, "source file should be kept on failure")
+}
+
+func TestStoreLayout(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	fs := FilesystemConfig{
+		SaveDir:  path.Join(tmp, "store"),
+		FileMode: 0o640,
+		DirMode:  0o750,
+		Layout:   "{prefix}/{yyyy}/{mm}/",
+	}
+	r.NoError(os.Mkdir(fs.SaveDir, 0o755), "failed to create store directory")
+
+	names := []string{"app-20241231230000.sql", "app-20250101000000.sql", "app-20250201000000.sql"}
+	for _, name := range names {
+		src := path.Join(tmp, name)
+		r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+		r.NoError(fs.Store(src, "db", name), "failed to store file")
+	}
+
+	info, err := os.Stat(path.Join(fs.SaveDir, "db", "2025", "01", "app-20250101000000.sql"))
+	r.NoError(err, "backup should be stored on the dated directory")
+	r.Equal(os.FileMode(0o640), info.Mode().Perm())
+
+	info, err = os.Stat(path.Join(fs.SaveDir, "db", "2025", "01"))
+	r.NoError(err)
+	r.Equal(os.FileMode(0o750), info.Mode().Perm(), "created directories should use the configured mode")
+
+	latest, err := fs.FindLatestBackup("db", "app")
+	r.NoError(err)
+	r.Equal("db/2025/02/app-20250201000000.sql", latest, "latest backup should be found across directories")
+
+	filepath, err := fs.Retrieve(latest)
+	r.NoError(err)
+	r.FileExists(filepath)
+
+	r.NoError(fs.RemoveOlderBackups("db", "app", RetentionPolicy{Keep: 2}))
+	r.NoFileExists(path.Join(fs.SaveDir, "db", "2024", "12", "app-20241231230000.sql"), "oldest backup should be removed")
+	r.NoDirExists(path.Join(fs.SaveDir, "db", "2024"), "empty directories should be removed")
+	r.DirExists(path.Join(fs.SaveDir, "db"), "the prefix directory should be kept")
+
+	files, err := fs.getFileListing("db", "app")
+	r.NoError(err)
+	r.Equal([]string{"db/2025/01/app-20250101000000.sql", "db/2025/02/app-20250201000000.sql"}, backupNames(files))
+}
+
+func TestStoreSizeQuota(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	fs := FilesystemConfig{SaveDir: tmp}
+
+	names := []string{
+		"app/app-20250101000000.sql",
+		"app/app-20250102000000.sql",
+		"users/users-20250101120000.sql",
+		"users/users-20250102120000.sql",
+	}
+	for _, name := range names {
+		r.NoError(os.MkdirAll(path.Join(tmp, path.Dir(name)), 0o755), "failed to create backup directory")
+		r.NoError(os.WriteFile(path.Join(tmp, name), make([]byte, 100), 0o600), "failed to create backup file")
+	}
+
+	r.NoError(fs.RemoveOlderBackups("app", "app", RetentionPolicy{MaxStoreSize: 250}))
+	r.NoFileExists(path.Join(tmp, names[0]), "the oldest backup of the store should be removed")
+	r.NoFileExists(path.Join(tmp, names[2]), "backups of other prefixes should count on the store quota")
+	r.FileExists(path.Join(tmp, names[1]), "the most recent backup of each prefix should be kept")
+	r.FileExists(path.Join(tmp, names[3]), "the most recent backup of each prefix should be kept")
+}
+
+func TestFilesystemOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner needs root")
+	}
+
+	r := require.New(t)
+	file := path.Join(t.TempDir(), "test-20250101000000.sql")
+	r.NoError(os.WriteFile(file, []byte("test"), 0o600), "failed to create backup file")
+
+	owner := func() (uint32, uint32) {
+		info, err := os.Stat(file)
+		r.NoError(err)
+		stat := info.Sys().(*syscall.Stat_t)
+		return stat.Uid, stat.Gid
+	}
+
+	r.NoError(os.Chown(file, 1000, 1000))
+
+	fs := FilesystemConfig{}
+	r.NoError(fs.setPermissions(file, 0))
+	uid, gid := owner()
+	r.Equal([]uint32{1000, 1000}, []uint32{uid, gid}, "the owner should be kept if unset")
+
+	root := 0
+	fs = FilesystemConfig{GID: &root}
+	r.NoError(fs.setPermissions(file, 0))
+	uid, gid = owner()
+	r.Equal([]uint32{1000, 0}, []uint32{uid, gid}, "only the group should change to root")
+
+	fs = FilesystemConfig{UID: &root}
+	r.NoError(fs.setPermissions(file, 0))
+	uid, gid = owner()
+	r.Equal([]uint32{0, 0}, []uint32{uid, gid}, "the owner should change to root")
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// FTPConfig has the config options for the FTP service
+type FTPConfig struct {
+	Host                  string
+	Port                  string
+	User                  string
+	Password              string
+	RemoteDir             string
+	TLSMode               string
+	TLSServerName         string
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
+	DisableEPSV           bool
+	Timeout               time.Duration
+	KeepAfterUpload       bool
+	SaveDir               string
+	retrievedFile         string
+}
+
+func (f *FTPConfig) newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         f.TLSServerName,
+		InsecureSkipVerify: f.TLSInsecureSkipVerify,
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = f.Host
+	}
+
+	if f.TLSInsecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled for the FTP store")
+	}
+
+	if f.TLSCAFile != "" {
+		pem, err := os.ReadFile(f.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file %s, %v", f.TLSCAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found on %s", f.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+func (f *FTPConfig) newConn() (*ftp.ServerConn, error) {
+	// the client always uses passive mode, EPSV can be disabled for servers that only understand PASV
+	options := []ftp.DialOption{
+		ftp.DialWithDisabledEPSV(f.DisableEPSV),
+	}
+
+	if f.Timeout > 0 {
+		options = append(options, ftp.DialWithTimeout(f.Timeout))
+	}
+
+	switch f.TLSMode {
+	case "", "none":
+	case "explicit", "implicit":
+		tlsConfig, err := f.newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		if f.TLSMode == "explicit" {
+			options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+		} else {
+			options = append(options, ftp.DialWithTLS(tlsConfig))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported FTP TLS mode %q, use none, explicit or implicit", f.TLSMode)
+	}
+
+	port := f.Port
+	if port == "" {
+		port = "21"
+	}
+
+	conn, err := ftp.Dial(net.JoinHostPort(f.Host, port), options...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to FTP server %s, %v", f.Host, err)
+	}
+
+	if err = conn.Login(f.User, f.Password); err != nil {
+		_ = conn.Quit()
+		return nil, fmt.Errorf("cannot login to FTP server %s, %v", f.Host, err)
+	}
+
+	return conn, nil
+}
+
+func closeFTPConn(conn *ftp.ServerConn) {
+	if err := conn.Quit(); err != nil {
+		slog.Warn("Cannot close FTP connection", "error", err)
+	}
+}
+
+func (f *FTPConfig) makeDirAll(conn *ftp.ServerConn, dir string) {
+	current := ""
+	if strings.HasPrefix(dir, "/") {
+		current = "/"
+	}
+
+	for _, part := range strings.Split(path.Clean(dir), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+
+		current = path.Join(current, part)
+		// the directory may exist already, any real error will show up on upload
+		if err := conn.MakeDir(current); err != nil {
+			slog.Debug("Cannot create remote directory", "path", current, "error", err)
+		}
+	}
+}
+
+// Store saves a file to a remote FTP server
+func (f *FTPConfig) Store(filepath, prefix, filename string) error {
+	conn, err := f.newConn()
+	if err != nil {
+		return err
+	}
+
+	defer closeFTPConn(conn)
+
+	file, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer func(file *os.File) {
+		closeErr := file.Close()
+		if closeErr != nil {
+			slog.Error("Cannot close file", "path", filepath, "error", closeErr)
+		}
+	}(file)
+
+	if !f.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err = os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	dest := path.Clean(path.Join(f.RemoteDir, prefix, filename))
+	f.makeDirAll(conn, path.Dir(dest))
+
+	if err = conn.Stor(dest, file); err != nil {
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	slog.Debug("File uploaded", "location", dest)
+
+	return nil
+}
+
+func (f *FTPConfig) getFileListing(basedir, namePrefix string, conn *ftp.ServerConn) ([]string, error) {
+	fullBasedir := path.Clean(path.Join(f.RemoteDir, basedir))
+	entries, err := conn.List(fullBasedir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list contents of remote directory %s, %v", fullBasedir, err)
+	}
+	re := generatePattern(namePrefix)
+
+	var filenames []string
+	for _, entry := range entries {
+		if entry.Type == ftp.EntryTypeFile {
+			// ignore files not created by this program
+			name := path.Base(entry.Name)
+			if re.MatchString(name) {
+				filenames = append(filenames, path.Join(fullBasedir, name))
+			}
+		}
+	}
+
+	return filenames, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the FTP server and deletes the old ones
+func (f *FTPConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	conn, err := f.newConn()
+	if err != nil {
+		return err
+	}
+
+	defer closeFTPConn(conn)
+
+	files, err := f.getFileListing(basedir, namePrefix, conn)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := 0
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			if err = conn.Delete(file); err != nil {
+				slog.Error("Failed to remove remote file", "name", file, "error", err)
+			} else {
+				deleted++
+			}
+		}
+
+		slog.Debug("Deleted objects from FTP server", "count", deleted, "path", path.Join(f.RemoteDir, basedir))
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the FTP server
+func (f *FTPConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	conn, err := f.newConn()
+	if err != nil {
+		return "", err
+	}
+
+	defer closeFTPConn(conn)
+
+	files, err := f.getFileListing(basedir, namePrefix, conn)
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on ftp://%s/%s", f.Host, f.RemoteDir)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads a remote file to the local filesystem
+func (f *FTPConfig) Retrieve(remotePath string) (string, error) {
+	conn, err := f.newConn()
+	if err != nil {
+		return "", err
+	}
+
+	defer closeFTPConn(conn)
+
+	res, err := conn.Retr(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("cannot open remote file %s, %v", remotePath, err)
+	}
+
+	defer res.Close()
+
+	filepath := path.Join(f.SaveDir, path.Base(remotePath))
+	file, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer file.Close()
+
+	if _, err = io.Copy(file, res); err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download remote file, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	f.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (f *FTPConfig) Close() {
+	if f.retrievedFile != "" {
+		if err := os.Remove(f.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", f.retrievedFile, "error", err)
+		}
+
+		f.retrievedFile = ""
+	}
+}
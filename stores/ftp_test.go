@@ -0,0 +1,292 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	ftpTestUser     = "backup"
+	ftpTestPassword = "secret"
+)
+
+// newFTPServer serves root with a minimal FTP server that only understands the commands used by the store.
+// It returns the port of the control connection.
+func newFTPServer(t *testing.T, root string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "failed to start FTP server")
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFTP(conn, root)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	return port
+}
+
+func serveFTP(conn net.Conn, root string) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var user string
+	var passive net.Listener
+	defer func() {
+		if passive != nil {
+			_ = passive.Close()
+		}
+	}()
+
+	// dataConn accepts the passive connection that the client opens before sending the transfer command
+	dataConn := func() (net.Conn, error) {
+		if passive == nil {
+			return nil, fmt.Errorf("no passive connection")
+		}
+		defer func() {
+			_ = passive.Close()
+			passive = nil
+		}()
+		return passive.Accept()
+	}
+
+	reply("220 test server ready")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		local := filepath.Join(root, filepath.FromSlash(path.Clean("/"+arg)))
+
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			user = arg
+			reply("331 password required")
+		case "PASS":
+			if user != ftpTestUser || arg != ftpTestPassword {
+				reply("530 login incorrect")
+			} else {
+				reply("230 logged in")
+			}
+		case "TYPE":
+			reply("200 type set")
+		case "EPSV":
+			if passive, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 cannot open data connection")
+				continue
+			}
+			reply("229 Entering Extended Passive Mode (|||%d|)", passive.Addr().(*net.TCPAddr).Port)
+		case "LIST":
+			data, err := dataConn()
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			entries, err := os.ReadDir(local)
+			if err != nil {
+				_ = data.Close()
+				reply("550 %v", err)
+				continue
+			}
+			reply("150 listing")
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				mode := "-rw-r--r--"
+				if entry.IsDir() {
+					mode = "drwxr-xr-x"
+				}
+				_, _ = fmt.Fprintf(data, "%s 1 ftp ftp %d %s %s\r\n", mode, info.Size(), info.ModTime().Format("Jan _2 15:04"), entry.Name())
+			}
+			_ = data.Close()
+			reply("226 transfer complete")
+		case "STOR":
+			data, err := dataConn()
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			file, err := os.Create(local)
+			if err != nil {
+				_ = data.Close()
+				reply("550 %v", err)
+				continue
+			}
+			reply("150 receiving")
+			_, err = io.Copy(file, data)
+			_ = file.Close()
+			_ = data.Close()
+			if err != nil {
+				reply("451 %v", err)
+				continue
+			}
+			reply("226 transfer complete")
+		case "RETR":
+			data, err := dataConn()
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			file, err := os.Open(local)
+			if err != nil {
+				_ = data.Close()
+				reply("550 %v", err)
+				continue
+			}
+			reply("150 sending")
+			_, _ = io.Copy(data, file)
+			_ = file.Close()
+			_ = data.Close()
+			reply("226 transfer complete")
+		case "DELE":
+			if err = os.Remove(local); err != nil {
+				reply("550 %v", err)
+			} else {
+				reply("250 deleted")
+			}
+		case "MKD":
+			if err = os.Mkdir(local, 0o755); err != nil {
+				reply("550 %v", err)
+			} else {
+				reply("257 %q created", arg)
+			}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func TestFTPStoreRetrieve(t *testing.T) {
+	r := require.New(t)
+	root := t.TempDir()
+	tmp := t.TempDir()
+
+	store := &FTPConfig{
+		Host:      "127.0.0.1",
+		Port:      newFTPServer(t, root),
+		User:      ftpTestUser,
+		Password:  ftpTestPassword,
+		RemoteDir: "/backups",
+		SaveDir:   tmp,
+	}
+
+	// LIST fails on a directory that doesn't exist until the first upload
+	_, err := store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "cannot list contents", "a missing directory should be reported")
+
+	names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "other-20250103000000.sql"}
+	for _, name := range names {
+		src := path.Join(tmp, name)
+		r.NoError(os.WriteFile(src, []byte(name), 0o600), "failed to create backup file")
+		r.NoError(store.Store(src, "db", name), "failed to store file")
+		r.NoFileExists(src, "source file should be removed after upload")
+	}
+
+	r.NoError(os.WriteFile(filepath.Join(root, "backups", "db", "notes.txt"), []byte("notes"), 0o600))
+	r.NoError(os.Mkdir(filepath.Join(root, "backups", "db", "test-20250104000000.sql"), 0o755))
+
+	r.NoError(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 1}))
+
+	entries, err := os.ReadDir(filepath.Join(root, "backups", "db"))
+	r.NoError(err)
+	var remaining []string
+	for _, entry := range entries {
+		remaining = append(remaining, entry.Name())
+	}
+	r.Equal([]string{"notes.txt", "other-20250103000000.sql", "test-20250102000000.sql", "test-20250104000000.sql"}, remaining,
+		"the retention should skip directories, unrelated files and other name prefixes")
+
+	latest, err := store.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal("/backups/db/test-20250102000000.sql", latest, "directories should not be returned as backups")
+
+	local, err := store.Retrieve(latest)
+	r.NoError(err, "failed to retrieve backup")
+	actual, err := os.ReadFile(local)
+	r.NoError(err, "failed to read retrieved file")
+	r.Equal("test-20250102000000.sql", string(actual))
+
+	store.Close()
+	r.NoFileExists(local, "retrieved file should be removed on close")
+
+	_, err = store.Retrieve("/backups/db/test-20250101000000.sql")
+	r.ErrorContains(err, "cannot open remote file", "removed backups cannot be retrieved")
+	r.NoFileExists(path.Join(tmp, "test-20250101000000.sql"), "a failed download should not leave a file")
+}
+
+func TestFTPStoreErrors(t *testing.T) {
+	r := require.New(t)
+	root := t.TempDir()
+	tmp := t.TempDir()
+
+	store := &FTPConfig{
+		Host:      "127.0.0.1",
+		Port:      newFTPServer(t, root),
+		User:      ftpTestUser,
+		Password:  "wrong",
+		RemoteDir: "/backups",
+		SaveDir:   tmp,
+	}
+
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("backup"), 0o600), "failed to create backup file")
+
+	err := store.Store(src, "db", "test-20250101000000.sql")
+	r.ErrorContains(err, "cannot login", "a wrong password should be reported")
+	r.FileExists(src, "the source file should be kept if the upload never started")
+
+	store.Password = ftpTestPassword
+	store.TLSMode = "starttls"
+	err = store.Store(src, "db", "test-20250101000000.sql")
+	r.ErrorContains(err, "unsupported FTP TLS mode")
+	r.FileExists(src, "the source file should be kept if the upload never started")
+
+	// the upload fails because a file is in the place of the remote directory
+	store.TLSMode = ""
+	r.NoError(os.WriteFile(filepath.Join(root, "backups"), nil, 0o600))
+	err = store.Store(src, "db", "test-20250101000000.sql")
+	r.ErrorContains(err, "failed to upload file")
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// GCSConfig has the config options for the Google Cloud Storage service
+type GCSConfig struct {
+	Endpoint        string
+	CredentialsFile string
+	NoAuth          bool
+	Bucket          string
+	Prefix          string
+	ChunkSize       int
+	KeepAfterUpload bool
+	SaveDir         string
+	retrievedFile   string
+}
+
+func (g *GCSConfig) newClient(ctx context.Context) (*storage.Client, error) {
+	// use the JSON API for downloads, so it works with custom endpoints too
+	opts := []option.ClientOption{storage.WithJSONReads()}
+
+	if g.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(g.Endpoint))
+	}
+
+	switch {
+	case g.NoAuth:
+		opts = append(opts, option.WithoutAuthentication())
+	case g.CredentialsFile != "":
+		opts = append(opts, option.WithCredentialsFile(g.CredentialsFile))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create GCS client, %v", err)
+	}
+
+	return client, nil
+}
+
+func closeGCSClient(client *storage.Client) {
+	if err := client.Close(); err != nil {
+		slog.Warn("Cannot close GCS client", "error", err)
+	}
+}
+
+// Store saves a file to a remote GCS bucket
+func (g *GCSConfig) Store(filepath, prefix, filename string) error {
+	ctx := context.Background()
+
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer closeGCSClient(client)
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer func(f *os.File) {
+		closeErr := f.Close()
+		if closeErr != nil {
+			slog.Error("Cannot close file", "path", filepath, "error", closeErr)
+		}
+	}(f)
+
+	if !g.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err = os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	key := path.Clean(path.Join(g.Prefix, prefix, filename))
+
+	// closing the writer commits the object, a failed upload is canceled instead so a
+	// truncated backup is never published
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the writer does a resumable upload, sending the file in chunks of ChunkSize
+	w := client.Bucket(g.Bucket).Object(key).NewWriter(uploadCtx)
+	if g.ChunkSize > 0 {
+		w.ChunkSize = g.ChunkSize
+	}
+
+	if _, err = io.Copy(w, f); err != nil {
+		cancel()
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	slog.Debug("File uploaded", "bucket", g.Bucket, "key", key)
+
+	return nil
+}
+
+func (g *GCSConfig) getFileListing(ctx context.Context, basedir, namePrefix string, client *storage.Client) ([]string, error) {
+	var files []string
+	re := generatePattern(namePrefix)
+
+	// make sure that the prefix ends with "/"
+	prefix := path.Clean(path.Join(g.Prefix, basedir)) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	it := client.Bucket(g.Bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// ignore files not created by this program
+		if re.MatchString(path.Base(attrs.Name)) {
+			files = append(files, attrs.Name)
+		}
+	}
+
+	return files, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the GCS bucket and deletes the old ones
+func (g *GCSConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	ctx := context.Background()
+
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer closeGCSClient(client)
+
+	files, err := g.getFileListing(ctx, basedir, namePrefix, client)
+	if err != nil {
+		return fmt.Errorf("couldn't list GCS objects, %v", err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := 0
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			slog.Debug("Marked to delete", "bucket", g.Bucket, "file", file)
+			if err = client.Bucket(g.Bucket).Object(file).Delete(ctx); err != nil {
+				slog.Error("Failed to remove object", "name", file, "error", err)
+			} else {
+				deleted++
+			}
+		}
+
+		slog.Debug("Deleted objects from GCS", "count", deleted)
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the GCS bucket
+func (g *GCSConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	ctx := context.Background()
+
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	defer closeGCSClient(client)
+
+	files, err := g.getFileListing(ctx, basedir, namePrefix, client)
+	if err != nil {
+		return "", fmt.Errorf("couldn't list GCS objects, %v", err)
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on gs://%s/%s", g.Bucket, g.Prefix)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads a GCS object to the local filesystem
+func (g *GCSConfig) Retrieve(key string) (string, error) {
+	ctx := context.Background()
+
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	defer closeGCSClient(client)
+
+	reader, err := client.Bucket(g.Bucket).Object(key).NewReader(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot open GCS object %s, %v", key, err)
+	}
+
+	defer reader.Close()
+
+	filepath := path.Join(g.SaveDir, path.Base(key))
+	f, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer f.Close()
+
+	if _, err = io.Copy(f, reader); err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download GCS object, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	g.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (g *GCSConfig) Close() {
+	if g.retrievedFile != "" {
+		if err := os.Remove(g.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", g.retrievedFile, "error", err)
+		}
+
+		g.retrievedFile = ""
+	}
+}
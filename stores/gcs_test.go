@@ -0,0 +1,114 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCSStoreRetrieve(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+	})
+	r.NoError(err, "failed to start fake GCS server")
+	defer server.Stop()
+
+	store := &GCSConfig{
+		Endpoint:  server.URL() + "/storage/v1/",
+		NoAuth:    true,
+		Bucket:    "test",
+		Prefix:    "backups",
+		ChunkSize: 256 * 1024,
+		SaveDir:   tmp,
+	}
+
+	// the bucket is never created by the store
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("backup"), 0o600), "failed to create backup file")
+	r.ErrorContains(store.Store(src, "db", "test-20250101000000.sql"), "failed to upload file", "a missing bucket should be reported")
+	_, err = store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "couldn't list GCS objects", "a missing bucket should be reported")
+
+	server.CreateBucket("test")
+
+	_, err = store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "cannot find a recent backup", "an empty prefix should be reported")
+
+	names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "test-20250103000000.sql"}
+	for _, name := range names {
+		src = path.Join(tmp, name)
+		err = os.WriteFile(src, []byte(name), 0o600)
+		r.NoError(err, "failed to create backup file")
+
+		err = store.Store(src, "db", name)
+		r.NoError(err, "failed to store file")
+		r.NoFileExists(src, "source file should be removed after upload")
+	}
+
+	// objects that share the key prefix but weren't created for this backup
+	unrelated := []string{"backups/db/notes.txt", "backups/db/other-20240101000000.sql", "backups/db2/test-20240101000000.sql"}
+	for _, key := range unrelated {
+		server.CreateObject(fakestorage.Object{
+			ObjectAttrs: fakestorage.ObjectAttrs{BucketName: "test", Name: key},
+			Content:     []byte(key),
+		})
+	}
+
+	err = store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2})
+	r.NoError(err, "failed to remove older backups")
+
+	_, err = server.GetObject("test", path.Join("backups", "db", names[0]))
+	r.Error(err, "oldest backup should be removed")
+
+	for _, key := range unrelated {
+		_, err = server.GetObject("test", key)
+		r.NoError(err, "unrelated object %s should be kept", key)
+	}
+
+	latest, err := store.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal(path.Join("backups", "db", names[2]), latest)
+
+	local, err := store.Retrieve(latest)
+	r.NoError(err, "failed to retrieve backup")
+	actual, err := os.ReadFile(local)
+	r.NoError(err, "failed to read retrieved file")
+	r.Equal(names[2], string(actual))
+
+	store.Close()
+	r.NoFileExists(local, "retrieved file should be removed on close")
+
+	_, err = store.Retrieve(path.Join("backups", "db", names[0]))
+	r.ErrorContains(err, "cannot open GCS object", "removed backups cannot be retrieved")
+	r.NoFileExists(path.Join(tmp, names[0]), "a failed download should not leave a file")
+
+	// reading a directory fails, the partial upload must not be committed
+	src = path.Join(tmp, "test-20250104000000.sql")
+	r.NoError(os.Mkdir(src, 0o755))
+	r.ErrorContains(store.Store(src, "db", path.Base(src)), "failed to upload file")
+	_, err = server.GetObject("test", path.Join("backups", "db", path.Base(src)))
+	r.Error(err, "a failed upload should not create the object")
+}
@@ -0,0 +1,432 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HTTPConfig has the config options for the HTTP service
+type HTTPConfig struct {
+	URL             string
+	Headers         map[string]string
+	User            string
+	Password        string
+	BearerToken     string
+	ListingMode     string
+	ListURL         string
+	ManifestName    string
+	KeepAfterUpload bool
+	SaveDir         string
+	retrievedFile   string
+	// manifestMu serializes the updates of the manifests, they are read, modified and written back
+	manifestMu sync.Mutex
+}
+
+const defaultManifestName = ".manifest.json"
+
+// httpListEntry is an entry of a JSON listing, compatible with nginx autoindex_format json
+type httpListEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (h *HTTPConfig) newRequest(method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s request, %v", method, err)
+	}
+
+	switch {
+	case h.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	case h.User != "" || h.Password != "":
+		req.SetBasicAuth(h.User, h.Password)
+	}
+
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if f, ok := body.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			req.ContentLength = info.Size()
+		}
+	}
+
+	return req, nil
+}
+
+func (h *HTTPConfig) objectURL(key string) (string, error) {
+	base, err := url.Parse(h.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid HTTP url %s, %v", h.URL, err)
+	}
+
+	return base.JoinPath(key).String(), nil
+}
+
+func (h *HTTPConfig) do(method, key string, body io.Reader, expected ...int) (*http.Response, error) {
+	rawURL, err := h.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := h.newRequest(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed, %v", method, key, err)
+	}
+
+	for _, code := range expected {
+		if res.StatusCode == code {
+			return res, nil
+		}
+	}
+
+	_ = res.Body.Close()
+
+	return nil, fmt.Errorf("%s %s failed with status %s", method, key, res.Status)
+}
+
+func (h *HTTPConfig) request(method, key string, body io.Reader, expected ...int) error {
+	res, err := h.do(method, key, body, expected...)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	return nil
+}
+
+func (h *HTTPConfig) manifestKey(dir string) string {
+	name := h.ManifestName
+	if name == "" {
+		name = defaultManifestName
+	}
+
+	return path.Join(dir, name)
+}
+
+func (h *HTTPConfig) readManifest(dir string) ([]string, error) {
+	res, err := h.do(http.MethodGet, h.manifestKey(dir), nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	// the manifest is created on the first upload
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var names []string
+	if err = json.NewDecoder(res.Body).Decode(&names); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest %s, %v", h.manifestKey(dir), err)
+	}
+
+	return names, nil
+}
+
+func (h *HTTPConfig) writeManifest(dir string, names []string) error {
+	sort.Strings(names)
+
+	data, err := json.Marshal(names)
+	if err != nil {
+		return fmt.Errorf("cannot encode manifest, %v", err)
+	}
+
+	return h.request(http.MethodPut, h.manifestKey(dir), bytes.NewReader(data),
+		http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+func (h *HTTPConfig) readJSONListing(dir string) ([]string, error) {
+	var rawURL string
+	var err error
+
+	if h.ListURL != "" {
+		placeholder := dir
+		if placeholder == "." {
+			placeholder = ""
+		}
+		rawURL = strings.ReplaceAll(h.ListURL, "{dir}", (&url.URL{Path: placeholder}).EscapedPath())
+	} else {
+		rawURL, err = h.objectURL(dir + "/")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := h.newRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get listing of %s, %v", dir, err)
+	}
+
+	defer res.Body.Close()
+
+	// the directory doesn't exist yet
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot get listing of %s, status %s", dir, res.Status)
+	}
+
+	var raw []json.RawMessage
+	if err = json.NewDecoder(res.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("cannot parse listing of %s, %v", dir, err)
+	}
+
+	// accept a list of names or a list of objects with a name field
+	var names []string
+	for _, item := range raw {
+		var name string
+		if err = json.Unmarshal(item, &name); err == nil {
+			names = append(names, name)
+			continue
+		}
+
+		var entry httpListEntry
+		if err = json.Unmarshal(item, &entry); err != nil {
+			return nil, fmt.Errorf("invalid entry on listing of %s, %v", dir, err)
+		}
+
+		if entry.Type == "" || entry.Type == "file" {
+			names = append(names, entry.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// Store saves a file to a remote HTTP server
+func (h *HTTPConfig) Store(filepath, prefix, filename string) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer func(f *os.File) {
+		closeErr := f.Close()
+		if closeErr != nil {
+			slog.Error("Cannot close file", "path", filepath, "error", closeErr)
+		}
+	}(f)
+
+	if !h.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err = os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	key := path.Clean(path.Join(prefix, filename))
+
+	err = h.request(http.MethodPut, key, f, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	slog.Debug("File uploaded", "location", key)
+
+	if h.ListingMode == "manifest" {
+		h.manifestMu.Lock()
+		defer h.manifestMu.Unlock()
+
+		dir := path.Dir(key)
+		names, err := h.readManifest(dir)
+		if err != nil {
+			return fmt.Errorf("cannot update manifest, %v", err)
+		}
+
+		// the file was uploaded again, it's already listed
+		if slices.Contains(names, filename) {
+			return nil
+		}
+
+		if err = h.writeManifest(dir, append(names, filename)); err != nil {
+			return fmt.Errorf("cannot update manifest, %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (h *HTTPConfig) getFileListing(basedir, namePrefix string) ([]string, error) {
+	dir := path.Clean(basedir)
+
+	var names []string
+	var err error
+
+	switch h.ListingMode {
+	case "json":
+		names, err = h.readJSONListing(dir)
+	case "manifest":
+		names, err = h.readManifest(dir)
+	default:
+		return nil, fmt.Errorf("unsupported HTTP listing mode %q, use json or manifest", h.ListingMode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	re := generatePattern(namePrefix)
+
+	var files []string
+	for _, name := range names {
+		// ignore files not created by this program
+		if re.MatchString(path.Base(name)) {
+			files = append(files, path.Join(dir, path.Base(name)))
+		}
+	}
+
+	return files, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the HTTP server and deletes the old ones
+func (h *HTTPConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	files, err := h.getFileListing(basedir, namePrefix)
+	if err != nil {
+		return fmt.Errorf("couldn't list HTTP files, %v", err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := map[string]bool{}
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			err = h.request(http.MethodDelete, file, nil, http.StatusOK, http.StatusAccepted, http.StatusNoContent, http.StatusNotFound)
+			if err != nil {
+				slog.Error("Failed to remove remote file", "name", file, "error", err)
+			} else {
+				deleted[path.Base(file)] = true
+			}
+		}
+
+		slog.Debug("Deleted objects from HTTP server", "count", len(deleted), "path", basedir)
+	}
+
+	if h.ListingMode == "manifest" && len(deleted) > 0 {
+		h.manifestMu.Lock()
+		defer h.manifestMu.Unlock()
+
+		dir := path.Clean(basedir)
+		names, err := h.readManifest(dir)
+		if err != nil {
+			return fmt.Errorf("cannot update manifest, %v", err)
+		}
+
+		var remaining []string
+		for _, name := range names {
+			if !deleted[name] {
+				remaining = append(remaining, name)
+			}
+		}
+
+		if err = h.writeManifest(dir, remaining); err != nil {
+			return fmt.Errorf("cannot update manifest, %v", err)
+		}
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the HTTP server
+func (h *HTTPConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	files, err := h.getFileListing(basedir, namePrefix)
+	if err != nil {
+		return "", fmt.Errorf("couldn't list HTTP files, %v", err)
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on %s", h.URL)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads a remote file to the local filesystem
+func (h *HTTPConfig) Retrieve(key string) (string, error) {
+	res, err := h.do(http.MethodGet, key, nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	filepath := path.Join(h.SaveDir, path.Base(key))
+	f, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer f.Close()
+
+	if _, err = io.Copy(f, res.Body); err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download remote file, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	h.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (h *HTTPConfig) Close() {
+	if h.retrievedFile != "" {
+		if err := os.Remove(h.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", h.retrievedFile, "error", err)
+		}
+
+		h.retrievedFile = ""
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newArtifactServer returns a minimal artifact server with a nginx-style JSON listing
+func newArtifactServer(t *testing.T) (*httptest.Server, map[string][]byte) {
+	files := map[string][]byte{}
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		key := strings.TrimPrefix(req.URL.Path, "/repo/")
+
+		switch req.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(req.Body)
+			files[key] = data
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			delete(files, key)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			if strings.HasSuffix(req.URL.Path, "/") {
+				var entries []httpListEntry
+				for name := range files {
+					if path.Dir(name) == path.Clean(key) {
+						entries = append(entries, httpListEntry{Name: path.Base(name), Type: "file"})
+					}
+				}
+				_ = json.NewEncoder(w).Encode(entries)
+				return
+			}
+
+			data, ok := files[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, files
+}
+
+func TestHTTPStoreRetrieve(t *testing.T) {
+	for _, mode := range []string{"json", "manifest"} {
+		t.Run(mode, func(t *testing.T) {
+			r := require.New(t)
+			tmp := t.TempDir()
+			server, files := newArtifactServer(t)
+
+			store := &HTTPConfig{
+				URL:         server.URL + "/repo",
+				BearerToken: "secret",
+				ListingMode: mode,
+				SaveDir:     tmp,
+			}
+
+			names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "test-20250103000000.sql"}
+			for _, name := range names {
+				src := path.Join(tmp, name)
+				err := os.WriteFile(src, []byte(name), 0o600)
+				r.NoError(err, "failed to create backup file")
+
+				err = store.Store(src, "db", name)
+				r.NoError(err, "failed to store file")
+			}
+
+			// a retried upload replaces the file and must not be listed twice
+			src := path.Join(tmp, names[2])
+			r.NoError(os.WriteFile(src, []byte(names[2]), 0o600), "failed to create backup file")
+			r.NoError(store.Store(src, "db", names[2]), "failed to store file again")
+
+			err := store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2})
+			r.NoError(err, "failed to remove older backups")
+			r.NotContains(files, path.Join("db", names[0]), "oldest backup should be removed")
+
+			latest, err := store.FindLatestBackup("db", "test")
+			r.NoError(err, "failed to find latest backup")
+			r.Equal(path.Join("db", names[2]), latest)
+
+			if mode == "manifest" {
+				var manifest []string
+				r.NoError(json.Unmarshal(files[path.Join("db", defaultManifestName)], &manifest))
+				r.Equal(names[1:], manifest, "manifest should only list the remaining backups")
+			}
+
+			local, err := store.Retrieve(latest)
+			r.NoError(err, "failed to retrieve backup")
+			actual, err := os.ReadFile(local)
+			r.NoError(err, "failed to read retrieved file")
+			r.Equal(names[2], string(actual))
+
+			store.Close()
+			r.NoFileExists(local, "retrieved file should be removed on close")
+		})
+	}
+}
+
+func TestHTTPManifestConcurrentStore(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+	server, files := newArtifactServer(t)
+
+	store := &HTTPConfig{
+		URL:         server.URL + "/repo",
+		BearerToken: "secret",
+		ListingMode: "manifest",
+		SaveDir:     tmp,
+	}
+
+	// backups with different name prefixes on the same directory, like per-user postgres backups
+	var names []string
+	for _, prefix := range []string{"app", "auth", "billing", "reports", "users"} {
+		names = append(names, prefix+"-20250101000000.sql")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(names))
+
+	for _, name := range names {
+		src := path.Join(tmp, name)
+		r.NoError(os.WriteFile(src, []byte(name), 0o600), "failed to create backup file")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.Store(src, "db", name)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		r.NoError(err, "failed to store file")
+	}
+
+	var manifest []string
+	r.NoError(json.Unmarshal(files[path.Join("db", defaultManifestName)], &manifest))
+	r.ElementsMatch(names, manifest, "concurrent uploads should not lose manifest entries")
+}
+
+func TestHTTPRetrieveTruncated(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	// the connection is closed before the announced length is sent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		_, _ = io.WriteString(w, "truncated")
+	}))
+	defer server.Close()
+
+	store := &HTTPConfig{URL: server.URL, SaveDir: tmp}
+
+	_, err := store.Retrieve("db/test-20250101000000.sql")
+	r.ErrorContains(err, "failed to download remote file")
+	r.NoFileExists(path.Join(tmp, "test-20250101000000.sql"), "a failed download should not leave a file")
+}
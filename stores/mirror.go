@@ -0,0 +1,239 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+)
+
+// MirrorConfig has the config options for the Mirror service. Every backup is
+// sent to all the stores, restores use the first store that has a backup.
+type MirrorConfig struct {
+	Stores         []Storer
+	Names          []string
+	latestStore    int
+	latestBasedir  string
+	latestPrefix   string
+	latestResolved bool
+}
+
+func (m *MirrorConfig) name(i int) string {
+	if i < len(m.Names) {
+		return m.Names[i]
+	}
+
+	return fmt.Sprintf("store-%d", i)
+}
+
+// copyFile creates a hard link of src on dest, falling back to a full copy
+func copyFile(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("cannot open source file %s, %v", src, err)
+	}
+
+	defer srcFile.Close()
+
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("cannot create destination file %s, %v", dest, err)
+	}
+
+	if _, err = io.Copy(destFile, srcFile); err != nil {
+		_ = destFile.Close()
+		return fmt.Errorf("error while copying file, %v", err)
+	}
+
+	return destFile.Close()
+}
+
+// Store saves a file on every configured store. Each store receives its own copy of the file
+// since stores are free to move or delete it.
+func (m *MirrorConfig) Store(filepath, prefix, filename string) error {
+	return m.StoreWithMetadata(filepath, prefix, filename, nil)
+}
+
+// StoreWithMetadata saves a file on every configured store, passing the details of the
+// backup to the stores that support them
+func (m *MirrorConfig) StoreWithMetadata(filepath, prefix, filename string, metadata map[string]string) error {
+	// the stores can share the directory of the file, the filesystem store may even use its path as
+	// destination, so the original is moved to a hidden name that no store uses nor deletes
+	staged := path.Join(path.Dir(filepath), "."+path.Base(filepath)+".mirror")
+	if err := os.Rename(filepath, staged); err != nil {
+		return fmt.Errorf("cannot move file %s, %v", filepath, err)
+	}
+
+	defer func() {
+		if err := os.Remove(staged); err != nil {
+			slog.Warn("Cannot remove file", "path", staged, "error", err)
+		}
+	}()
+
+	var errs []error
+
+	for i, store := range m.Stores {
+		src := fmt.Sprintf("%s-%d", staged, i)
+		if err := copyFile(staged, src); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", m.name(i), err))
+			continue
+		}
+
+		slog.Debug("Storing file on mirror", "store", m.name(i), "path", filepath)
+		if err := StoreWithMetadata(store, src, prefix, filename, metadata); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", m.name(i), err))
+		}
+
+		// the S3 store keeps the file of a failed upload to resume it on the next run
+		if hasUploadState(src) {
+			slog.Info("Keeping mirror copy to resume the upload", "store", m.name(i), "path", src)
+			continue
+		}
+
+		// remove the copy if the store didn't consume it
+		if err := os.Remove(src); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Cannot remove file", "path", src, "error", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ResumePendingUploads finishes the failed uploads of the stores that support it
+func (m *MirrorConfig) ResumePendingUploads() error {
+	var errs []error
+
+	for i, store := range m.Stores {
+		if uploader, ok := store.(PendingUploader); ok {
+			if err := uploader.ResumePendingUploads(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", m.name(i), err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RemoveOlderBackups applies the retention on every store separately
+func (m *MirrorConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	var errs []error
+
+	for i, store := range m.Stores {
+		if err := store.RemoveOlderBackups(basedir, namePrefix, policy); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", m.name(i), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// FindLatestBackup returns the most recent backup of the first store that has one
+func (m *MirrorConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	m.latestBasedir = basedir
+	m.latestPrefix = namePrefix
+
+	latest, i, err := m.findLatestBackupFrom(0)
+	if err != nil {
+		return "", err
+	}
+
+	m.latestStore = i
+	m.latestResolved = true
+
+	return latest, nil
+}
+
+func (m *MirrorConfig) findLatestBackupFrom(start int) (string, int, error) {
+	var errs []error
+
+	for i := start; i < len(m.Stores); i++ {
+		latest, err := m.Stores[i].FindLatestBackup(m.latestBasedir, m.latestPrefix)
+		if err != nil {
+			slog.Warn("Cannot find the latest backup on mirror, trying the next one", "store", m.name(i), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %v", m.name(i), err))
+			continue
+		}
+
+		return latest, i, nil
+	}
+
+	if len(errs) == 0 {
+		return "", -1, fmt.Errorf("no stores configured on the mirror")
+	}
+
+	return "", -1, errors.Join(errs...)
+}
+
+// Retrieve downloads the file from the store that returned it on FindLatestBackup. If the download
+// fails then the latest backup of the next stores is used instead. Files that weren't returned by
+// FindLatestBackup are searched on every store, in order.
+func (m *MirrorConfig) Retrieve(key string) (string, error) {
+	var errs []error
+
+	if !m.latestResolved {
+		for i, store := range m.Stores {
+			filepath, err := store.Retrieve(key)
+			if err != nil {
+				slog.Warn("Cannot retrieve backup from mirror, trying the next one", "store", m.name(i), "error", err)
+				errs = append(errs, fmt.Errorf("%s: %v", m.name(i), err))
+				continue
+			}
+
+			return filepath, nil
+		}
+
+		return "", errors.Join(errs...)
+	}
+
+	i := m.latestStore
+	for i >= 0 {
+		filepath, err := m.Stores[i].Retrieve(key)
+		if err == nil {
+			return filepath, nil
+		}
+
+		slog.Warn("Cannot retrieve backup from mirror, trying the next one", "store", m.name(i), "error", err)
+		errs = append(errs, fmt.Errorf("%s: %v", m.name(i), err))
+
+		if i+1 >= len(m.Stores) {
+			break
+		}
+
+		key, i, err = m.findLatestBackupFrom(i + 1)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// Close deinitializes every store
+func (m *MirrorConfig) Close() {
+	for _, store := range m.Stores {
+		store.Close()
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/require"
+)
+
+type brokenStore struct {
+	latest string
+}
+
+func (b *brokenStore) Store(_, _, _ string) error { return fmt.Errorf("store is broken") }
+
+func (b *brokenStore) Retrieve(_ string) (string, error) {
+	return "", fmt.Errorf("store is broken")
+}
+
+func (b *brokenStore) RemoveOlderBackups(_, _ string, _ RetentionPolicy) error { return nil }
+
+func (b *brokenStore) FindLatestBackup(_, _ string) (string, error) {
+	if b.latest == "" {
+		return "", fmt.Errorf("store is empty")
+	}
+	return b.latest, nil
+}
+
+func (b *brokenStore) Close() {}
+
+func TestMirrorStore(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	first := path.Join(tmp, "first")
+	second := path.Join(tmp, "second")
+	for _, dir := range []string{first, second} {
+		r.NoError(os.MkdirAll(path.Join(dir, "db"), 0o755), "failed to create store directory")
+	}
+
+	mirror := &MirrorConfig{
+		Stores: []Storer{&FilesystemConfig{SaveDir: first}, &FilesystemConfig{SaveDir: second}},
+		Names:  []string{"first", "second"},
+	}
+
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+
+	err := mirror.Store(src, "db", path.Base(src))
+	r.NoError(err, "failed to store file")
+
+	for _, dir := range []string{first, second} {
+		actual, err := os.ReadFile(path.Join(dir, "db", path.Base(src)))
+		r.NoError(err, "file should be stored on every store")
+		r.Equal([]byte("test"), actual)
+	}
+
+	leftovers, err := os.ReadDir(tmp)
+	r.NoError(err, "failed to list temp directory")
+	r.Len(leftovers, 2, "copies of the source file should be removed")
+}
+
+func TestMirrorStoreErrors(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	mirror := &MirrorConfig{
+		Stores: []Storer{&brokenStore{}, &FilesystemConfig{SaveDir: path.Join(tmp, "store")}},
+	}
+	r.NoError(os.MkdirAll(path.Join(tmp, "store"), 0o755), "failed to create store directory")
+
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+
+	err := mirror.Store(src, "", path.Base(src))
+	r.Error(err, "errors of any store should be reported")
+	r.FileExists(path.Join(tmp, "store", path.Base(src)), "working stores should still receive the file")
+}
+
+func TestMirrorRetrieveFallback(t *testing.T) {
+	r := require.New(t)
+
+	mirror := &MirrorConfig{
+		Stores: []Storer{
+			&brokenStore{},
+			&brokenStore{latest: "test-20250102000000.sql"},
+			&fixedStore{FilesystemConfig{SaveDir: "/backups"}, "test-20250101000000.sql"},
+		},
+	}
+
+	latest, err := mirror.FindLatestBackup("", "test")
+	r.NoError(err, "the second store should have the latest backup")
+	r.Equal("test-20250102000000.sql", latest)
+
+	filepath, err := mirror.Retrieve(latest)
+	r.NoError(err, "retrieve should fall back to the latest backup of the next store")
+	r.Equal("/backups/test-20250101000000.sql", filepath)
+
+	mirror = &MirrorConfig{
+		Stores: []Storer{&brokenStore{}, &FilesystemConfig{SaveDir: "/backups"}},
+	}
+
+	filepath, err = mirror.Retrieve("test-20250103000000.sql")
+	r.NoError(err, "retrieve should try every store")
+	r.Equal("/backups/test-20250103000000.sql", filepath)
+
+	mirror = &MirrorConfig{
+		Stores: []Storer{&brokenStore{}, &brokenStore{}},
+	}
+
+	_, err = mirror.FindLatestBackup("", "test")
+	r.Error(err, "no backups should be found")
+}
+
+type fixedStore struct {
+	FilesystemConfig
+	latest string
+}
+
+func (f *fixedStore) FindLatestBackup(_, _ string) (string, error) {
+	return f.latest, nil
+}
+
+type metadataStore struct {
+	FilesystemConfig
+	metadata map[string]string
+}
+
+func (m *metadataStore) StoreWithMetadata(filepath, prefix, filename string, metadata map[string]string) error {
+	m.metadata = metadata
+	return m.Store(filepath, prefix, filename)
+}
+
+func TestMirrorStoreWithMetadata(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	withMetadata := &metadataStore{FilesystemConfig: FilesystemConfig{SaveDir: path.Join(tmp, "first")}}
+	mirror := &MirrorConfig{
+		Stores: []Storer{withMetadata, &FilesystemConfig{SaveDir: path.Join(tmp, "second")}},
+	}
+
+	for _, dir := range []string{"first", "second"} {
+		r.NoError(os.MkdirAll(path.Join(tmp, dir), 0o755), "failed to create store directory")
+	}
+
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+
+	err := mirror.StoreWithMetadata(src, "", path.Base(src), map[string]string{"service": "postgres"})
+	r.NoError(err, "failed to store file")
+	r.Equal(map[string]string{"service": "postgres"}, withMetadata.metadata, "metadata should be passed to the stores")
+	r.FileExists(path.Join(tmp, "second", path.Base(src)), "stores without metadata should receive the file")
+}
+
+// consumingStore removes the source file after reading it, like the remote stores do
+type consumingStore struct {
+	brokenStore
+	contents []byte
+}
+
+func (c *consumingStore) Store(filepath, _, _ string) error {
+	contents, err := os.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+
+	c.contents = contents
+
+	return os.Remove(filepath)
+}
+
+func TestMirrorSharedSaveDir(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	remote := &consumingStore{}
+	mirror := &MirrorConfig{
+		Stores: []Storer{&FilesystemConfig{SaveDir: tmp}, remote},
+		Names:  []string{"filesystem", "remote"},
+	}
+
+	// the service writes the backup on the destination of the filesystem store
+	r.NoError(os.Mkdir(path.Join(tmp, "db"), 0o755), "failed to create backup directory")
+	src := path.Join(tmp, "db", "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+
+	err := mirror.Store(src, "db", path.Base(src))
+	r.NoError(err, "failed to store file")
+	r.Equal([]byte("test"), remote.contents, "the remote store should receive the file")
+
+	actual, err := os.ReadFile(src)
+	r.NoError(err, "the filesystem store should keep its backup")
+	r.Equal([]byte("test"), actual)
+
+	latest, err := mirror.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal("db/test-20250101000000.sql", latest)
+
+	leftovers, err := os.ReadDir(path.Join(tmp, "db"))
+	r.NoError(err, "failed to list backup directory")
+	r.Len(leftovers, 1, "copies of the source file should be removed")
+}
+
+func TestMirrorResumeS3Upload(t *testing.T) {
+	r := require.New(t)
+	resumableRetryDelay = time.Millisecond
+	tmp := t.TempDir()
+
+	server, store := newFakeS3Server(t)
+	server.failPart = "2"
+	store.ResumableUpload = true
+	store.PartSize = s3manager.MinUploadPartSize
+	store.SaveDir = tmp
+
+	mirror := &MirrorConfig{Stores: []Storer{store}, Names: []string{"s3"}}
+
+	r.NoError(os.Mkdir(path.Join(tmp, "db"), 0o755), "failed to create backup directory")
+	src := path.Join(tmp, "db", "test-20250101000000.sql")
+	size := 2*s3manager.MinUploadPartSize + 1
+	r.NoError(os.WriteFile(src, make([]byte, size), 0o600), "failed to create backup file")
+
+	err := mirror.Store(src, "db", path.Base(src))
+	r.ErrorContains(err, "failed to upload file")
+
+	copied := path.Join(tmp, "db", ".test-20250101000000.sql.mirror-0")
+	r.FileExists(copied, "the copy of the failed upload should be kept")
+	r.FileExists(uploadStatePath(copied), "the upload state should be kept")
+
+	// the next run continues the upload
+	r.NoError(mirror.ResumePendingUploads(), "pending upload should be resumed")
+	r.Equal(map[string]int{"db/test-20250101000000.sql": int(size)}, server.objects, "the upload should be completed")
+	r.NoFileExists(copied, "the copy should be removed after the upload")
+	r.NoFileExists(uploadStatePath(copied), "the state should be removed after the upload")
+}
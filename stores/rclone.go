@@ -0,0 +1,214 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"go.megpoid.dev/go-s3-backup/services"
+)
+
+// rcloneDirNotFound is the exit code of rclone when the listed directory doesn't exist
+const rcloneDirNotFound = 3
+
+// RcloneConfig has the config options for the rclone service
+type RcloneConfig struct {
+	Remote          string
+	ConfigFile      string
+	Binary          string
+	ExtraArgs       []string
+	KeepAfterUpload bool
+	SaveDir         string
+	retrievedFile   string
+}
+
+// rcloneListEntry is an entry of the rclone lsjson output
+type rcloneListEntry struct {
+	Path  string `json:"Path"`
+	Name  string `json:"Name"`
+	IsDir bool   `json:"IsDir"`
+}
+
+func (r *RcloneConfig) binary() string {
+	if r.Binary != "" {
+		return r.Binary
+	}
+
+	return "rclone"
+}
+
+// remotePath joins the key to the configured remote, for example dropbox:backups/db/file.sql
+func (r *RcloneConfig) remotePath(key string) string {
+	key = path.Clean(key)
+	if key == "." {
+		return r.Remote
+	}
+
+	if r.Remote == "" || strings.HasSuffix(r.Remote, ":") || strings.HasSuffix(r.Remote, "/") {
+		return r.Remote + key
+	}
+
+	return r.Remote + "/" + key
+}
+
+func (r *RcloneConfig) run(app *services.CmdConfig, args ...string) error {
+	var arg []string
+	if r.ConfigFile != "" {
+		arg = append(arg, "--config", r.ConfigFile)
+	}
+	arg = append(arg, r.ExtraArgs...)
+	arg = append(arg, args...)
+
+	if err := app.CmdRun(r.binary(), arg...); err != nil {
+		return fmt.Errorf("rclone %s failed, %w", args[0], err)
+	}
+
+	return nil
+}
+
+// Store saves a file to a rclone remote
+func (r *RcloneConfig) Store(filepath, prefix, filename string) error {
+	if !r.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err := os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	dest := r.remotePath(path.Join(prefix, filename))
+
+	if err := r.run(&services.CmdConfig{}, "copyto", filepath, dest); err != nil {
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	slog.Debug("File uploaded", "location", dest)
+
+	return nil
+}
+
+func (r *RcloneConfig) getFileListing(basedir, namePrefix string) ([]string, error) {
+	var out bytes.Buffer
+
+	dir := path.Clean(basedir)
+	if err := r.run(&services.CmdConfig{OutputFile: &out}, "lsjson", "--files-only", r.remotePath(dir)); err != nil {
+		// the directory doesn't exist until the first upload
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == rcloneDirNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []rcloneListEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		return nil, fmt.Errorf("cannot parse rclone listing, %v", err)
+	}
+
+	re := generatePattern(namePrefix)
+
+	var files []string
+	for _, entry := range entries {
+		// ignore files not created by this program
+		if !entry.IsDir && re.MatchString(entry.Name) {
+			files = append(files, path.Join(dir, entry.Name))
+		}
+	}
+
+	return files, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the rclone remote and deletes the old ones
+func (r *RcloneConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	files, err := r.getFileListing(basedir, namePrefix)
+	if err != nil {
+		return fmt.Errorf("couldn't list rclone files, %v", err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := 0
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			if err = r.run(&services.CmdConfig{}, "deletefile", r.remotePath(file)); err != nil {
+				slog.Error("Failed to remove remote file", "name", file, "error", err)
+			} else {
+				deleted++
+			}
+		}
+
+		slog.Debug("Deleted files from rclone remote", "count", deleted, "path", r.remotePath(basedir))
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the rclone remote
+func (r *RcloneConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	files, err := r.getFileListing(basedir, namePrefix)
+	if err != nil {
+		return "", fmt.Errorf("couldn't list rclone files, %v", err)
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on %s", r.remotePath(basedir))
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads a file from the rclone remote to the local filesystem
+func (r *RcloneConfig) Retrieve(key string) (string, error) {
+	filepath := path.Join(r.SaveDir, path.Base(key))
+
+	if err := r.run(&services.CmdConfig{}, "copyto", r.remotePath(key), filepath); err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download remote file, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	r.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (r *RcloneConfig) Close() {
+	if r.retrievedFile != "" {
+		if err := os.Remove(r.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", r.retrievedFile, "error", err)
+		}
+
+		r.retrievedFile = ""
+	}
+}
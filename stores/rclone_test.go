@@ -0,0 +1,172 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRcloneRemotePath(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("dropbox:db/test.sql", (&RcloneConfig{Remote: "dropbox:"}).remotePath("db/test.sql"))
+	r.Equal("dropbox:backups/db/test.sql", (&RcloneConfig{Remote: "dropbox:backups"}).remotePath("db/test.sql"))
+	r.Equal("/backups/test.sql", (&RcloneConfig{Remote: "/backups/"}).remotePath("./test.sql"))
+	r.Equal("dropbox:backups", (&RcloneConfig{Remote: "dropbox:backups"}).remotePath("."))
+}
+
+func TestRcloneStoreRetrieve(t *testing.T) {
+	if _, err := exec.LookPath("rclone"); err != nil {
+		t.Skip("rclone binary not found")
+	}
+
+	r := require.New(t)
+	tmp := t.TempDir()
+	remote := path.Join(tmp, "remote")
+	saveDir := path.Join(tmp, "save")
+	r.NoError(os.MkdirAll(saveDir, 0o755), "failed to create save directory")
+
+	// use the local backend without any config file
+	store := &RcloneConfig{
+		Remote:     ":local:" + remote,
+		ConfigFile: os.DevNull,
+		SaveDir:    saveDir,
+	}
+
+	// lsjson fails on a directory that doesn't exist until the first upload
+	r.NoError(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2}), "a missing directory has nothing to remove")
+	_, err := store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "cannot find a recent backup", "a missing directory should be an empty listing")
+
+	names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "test-20250103000000.sql"}
+	for _, name := range names {
+		src := path.Join(tmp, name)
+		err = os.WriteFile(src, []byte(name), 0o600)
+		r.NoError(err, "failed to create backup file")
+
+		err = store.Store(src, "db", name)
+		r.NoError(err, "failed to store file")
+		r.NoFileExists(src, "source file should be removed after upload")
+	}
+
+	r.NoError(os.WriteFile(path.Join(remote, "db", "other.txt"), []byte("other"), 0o600), "failed to create unrelated file")
+
+	r.NoError(os.Mkdir(path.Join(remote, "db", "test-20250104000000.sql"), 0o755), "failed to create unrelated directory")
+
+	err = store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2})
+	r.NoError(err, "failed to remove older backups")
+	r.NoFileExists(path.Join(remote, "db", names[0]), "old backup should be removed")
+	r.FileExists(path.Join(remote, "db", "other.txt"), "unrelated files should be kept")
+	r.DirExists(path.Join(remote, "db", "test-20250104000000.sql"), "directories should be kept")
+
+	latest, err := store.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal(path.Join("db", names[2]), latest)
+
+	local, err := store.Retrieve(latest)
+	r.NoError(err, "failed to retrieve backup")
+	actual, err := os.ReadFile(local)
+	r.NoError(err, "failed to read retrieved file")
+	r.Equal([]byte(names[2]), actual)
+
+	store.Close()
+	r.NoFileExists(local, "retrieved file should be removed on close")
+
+	_, err = store.Retrieve(path.Join("db", names[0]))
+	r.ErrorContains(err, "failed to download remote file", "removed backups cannot be retrieved")
+	r.NoFileExists(path.Join(saveDir, names[0]), "a failed download should not leave a file")
+}
+
+// fakeRclone emulates the rclone commands used by the store on local paths, the directories are listed too
+// so the listing filter is exercised, and the remotes with a colon fail like an unknown remote
+const fakeRclone = `#!/bin/sh
+[ "$1" = "--config" ] && shift 2
+case "$1" in
+copyto)
+	[ -f "$2" ] || exit 4
+	mkdir -p "$(dirname "$3")" && cp "$2" "$3" ;;
+deletefile)
+	rm "$2" ;;
+lsjson)
+	case "$3" in *:*) echo "didn't find section in config file" >&2; exit 1 ;; esac
+	[ -d "$3" ] || exit 3
+	printf '['
+	sep=''
+	for f in "$3"/*; do
+		[ -e "$f" ] || continue
+		dir=false
+		[ -d "$f" ] && dir=true
+		printf '%s{"Path":"%s","Name":"%s","IsDir":%s}' "$sep" "${f##*/}" "${f##*/}" "$dir"
+		sep=','
+	done
+	echo ']' ;;
+*)
+	exit 1 ;;
+esac
+`
+
+func TestRcloneListing(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+	remote := path.Join(tmp, "remote")
+
+	binary := path.Join(tmp, "rclone")
+	r.NoError(os.WriteFile(binary, []byte(fakeRclone), 0o755), "failed to create fake rclone")
+
+	store := &RcloneConfig{
+		Remote:     remote,
+		ConfigFile: os.DevNull,
+		Binary:     binary,
+		SaveDir:    tmp,
+	}
+
+	// exit code 3, the directory doesn't exist yet
+	files, err := store.getFileListing("db", "test")
+	r.NoError(err, "a missing directory should be an empty listing")
+	r.Empty(files)
+	r.NoError(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 1}), "a missing directory has nothing to remove")
+
+	for _, name := range []string{"test-20250101000000.sql", "test-20250102000000.sql", "notes.txt"} {
+		src := path.Join(tmp, name)
+		r.NoError(os.WriteFile(src, []byte(name), 0o600), "failed to create file")
+		r.NoError(store.Store(src, "db", name), "failed to store file")
+	}
+	r.NoError(os.Mkdir(path.Join(remote, "db", "test-20250103000000.sql"), 0o755))
+
+	files, err = store.getFileListing("db", "test")
+	r.NoError(err, "failed to list files")
+	r.Equal([]string{"db/test-20250101000000.sql", "db/test-20250102000000.sql"}, files, "only the backups should be listed")
+
+	r.NoError(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 1}))
+	r.NoFileExists(path.Join(remote, "db", "test-20250101000000.sql"), "old backup should be removed")
+	r.FileExists(path.Join(remote, "db", "notes.txt"), "unrelated files should be kept")
+	r.DirExists(path.Join(remote, "db", "test-20250103000000.sql"), "directories should be kept")
+
+	_, err = store.Retrieve("db/test-20250101000000.sql")
+	r.ErrorContains(err, "failed to download remote file", "removed backups cannot be retrieved")
+
+	// any other failure is reported
+	store.Remote = "missing:"
+	_, err = store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "couldn't list rclone files", "an unknown remote should be reported")
+	r.Error(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 1}), "an unknown remote should be reported")
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy decides which backups are removed from a store. A backup is kept if any of
+// the rules selects it, a policy without rules keeps every backup.
+type RetentionPolicy struct {
+	// Keep is the number of most recent backups to keep
+	Keep int
+	// Daily, Weekly, Monthly and Yearly keep the most recent backup of that many days,
+	// weeks, months and years that have backups
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+	// MaxAge removes the backups older than this, even if other rules keep them
+	MaxAge time.Duration
+	// MinCount is the number of most recent backups that are never removed
+	MinCount int
+	// MaxSize is the quota in bytes of the backups with the same prefix
+	MaxSize int64
+	// MaxStoreSize is the quota in bytes of all the backups of the store
+	MaxStoreSize int64
+}
+
+// backupFile is a backup found on a store
+type backupFile struct {
+	name string
+	// modTime and size are zero if the store doesn't provide them
+	modTime time.Time
+	size    int64
+}
+
+// datedBackup is a backup with its creation time
+type datedBackup struct {
+	backupFile
+	time time.Time
+}
+
+// backupFiles returns the backups of stores that only know their names
+func backupFiles(names []string) []backupFile {
+	files := make([]backupFile, len(names))
+	for i, name := range names {
+		files[i] = backupFile{name: name}
+	}
+
+	return files
+}
+
+// backupNames returns the names of the backups
+func backupNames(files []backupFile) []string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.name
+	}
+
+	return names
+}
+
+var backupTimestamp = regexp.MustCompile(`-([[:digit:]]{14})\.`)
+
+// anyBackupPattern matches the backups of every prefix
+var anyBackupPattern = regexp.MustCompile(`^[^.].*-[[:digit:]]{14}\.[[:alnum:].]+$`)
+
+// backupTime returns the creation time of a backup from the timestamp of its name
+func backupTime(filename string) (time.Time, bool) {
+	match := backupTimestamp.FindStringSubmatch(filename)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation("20060102150405", match[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// hasRules returns true if the policy selects the backups to keep
+func (p RetentionPolicy) hasRules() bool {
+	return p.Keep > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0 || p.Yearly > 0
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.hasRules() || p.MaxAge > 0 || p.MaxSize > 0
+}
+
+// protected returns the number of most recent backups that the max age and the size quotas
+// cannot remove. The most recent one is always kept, even if no backups were made for a while.
+func (p RetentionPolicy) protected() int {
+	return max(p.MinCount, 1)
+}
+
+// newestFirst returns the backups sorted from newest to oldest. The time of each backup is
+// the timestamp on the name or the modification time if the name doesn't have one, the size
+// of the backups without both is returned separately.
+func newestFirst(files []backupFile) ([]datedBackup, int64) {
+	var backups []datedBackup
+	var undated int64
+
+	for _, file := range files {
+		t, ok := backupTime(path.Base(file.name))
+		if !ok {
+			if file.modTime.IsZero() {
+				slog.Debug("Backup has no timestamp, keeping it", "file", file.name)
+				undated += file.size
+				continue
+			}
+			t = file.modTime
+		}
+
+		backups = append(backups, datedBackup{file, t})
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		if backups[i].time.Equal(backups[j].time) {
+			return backups[i].name > backups[j].name
+		}
+		return backups[i].time.After(backups[j].time)
+	})
+
+	return backups, undated
+}
+
+// seriesKey identifies the backups of the same prefix. The numeric directories of dated
+// layouts are left out.
+func seriesKey(name string) string {
+	var dirs []string
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if strings.Trim(dir, "0123456789") != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	base := path.Base(name)
+	if loc := backupTimestamp.FindStringIndex(base); loc != nil {
+		base = base[:loc[0]]
+	}
+
+	return path.Join(append(dirs, base)...)
+}
+
+// expired returns the backups that must be removed, from oldest to newest. It is used by the
+// stores that only know the names of the backups, so the size quotas cannot be applied.
+func (p RetentionPolicy) expired(files []string) []string {
+	if p.MaxSize > 0 || p.MaxStoreSize > 0 {
+		slog.Warn("The store doesn't report the size of the backups, ignoring the size quotas",
+			"max_size", p.MaxSize, "max_store_size", p.MaxStoreSize)
+	}
+
+	return p.expiredFiles(backupFiles(files), time.Now())
+}
+
+// expiredFiles returns the backups that must be removed, from oldest to newest. Backups
+// without a time are never removed.
+func (p RetentionPolicy) expiredFiles(files []backupFile, now time.Time) []string {
+	if !p.enabled() {
+		return nil
+	}
+
+	backups, usage := newestFirst(files)
+
+	keep := make([]bool, len(backups))
+	for i := 0; i < p.Keep && i < len(backups); i++ {
+		keep[i] = true
+	}
+
+	// without rules every backup is kept unless it's too old or over the quota
+	if !p.hasRules() {
+		for i := range keep {
+			keep[i] = true
+		}
+	}
+
+	periods := []struct {
+		count int
+		key   func(t time.Time) int
+	}{
+		{p.Daily, func(t time.Time) int { return t.Year()*1000 + t.YearDay() }},
+		{p.Weekly, func(t time.Time) int { year, week := t.ISOWeek(); return year*100 + week }},
+		{p.Monthly, func(t time.Time) int { return t.Year()*100 + int(t.Month()) }},
+		{p.Yearly, func(t time.Time) int { return t.Year() }},
+	}
+
+	// keep the most recent backup of each period
+	for _, period := range periods {
+		kept := 0
+		last := -1
+
+		for i := 0; i < len(backups) && kept < period.count; i++ {
+			if key := period.key(backups[i].time); key != last {
+				keep[i] = true
+				last = key
+				kept++
+			}
+		}
+	}
+
+	if p.MaxAge > 0 {
+		limit := now.Add(-p.MaxAge)
+		for i := range backups {
+			if backups[i].time.Before(limit) {
+				keep[i] = false
+			}
+		}
+	}
+
+	for i := 0; i < p.protected() && i < len(backups); i++ {
+		keep[i] = true
+	}
+
+	// remove the oldest backups until the rest fit on the quota
+	if p.MaxSize > 0 {
+		for i, backup := range backups {
+			if keep[i] {
+				usage += backup.size
+			}
+		}
+
+		for i := len(backups) - 1; i >= p.protected() && usage > p.MaxSize; i-- {
+			if keep[i] {
+				keep[i] = false
+				usage -= backups[i].size
+			}
+		}
+
+		if usage > p.MaxSize {
+			slog.Warn("The most recent backups don't fit on the size quota", "usage", usage, "quota", p.MaxSize)
+		}
+	}
+
+	var expired []string
+	for i := len(backups) - 1; i >= 0; i-- {
+		if !keep[i] {
+			expired = append(expired, backups[i].name)
+		}
+	}
+
+	return expired
+}
+
+// expiredByStoreSize returns the oldest backups of the store that must be removed so all of them
+// fit on the store quota. The most recent backups of each prefix are never removed.
+func (p RetentionPolicy) expiredByStoreSize(files []backupFile) []string {
+	if p.MaxStoreSize <= 0 {
+		return nil
+	}
+
+	backups, usage := newestFirst(files)
+
+	protected := make([]bool, len(backups))
+	seen := map[string]int{}
+
+	for i, backup := range backups {
+		usage += backup.size
+
+		key := seriesKey(backup.name)
+		protected[i] = seen[key] < p.protected()
+		seen[key]++
+	}
+
+	var expired []string
+	for i := len(backups) - 1; i >= 0 && usage > p.MaxStoreSize; i-- {
+		if !protected[i] {
+			expired = append(expired, backups[i].name)
+			usage -= backups[i].size
+		}
+	}
+
+	if usage > p.MaxStoreSize {
+		slog.Warn("The most recent backups don't fit on the store quota", "usage", usage, "quota", p.MaxStoreSize)
+	}
+
+	return expired
+}
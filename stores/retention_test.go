@@ -0,0 +1,196 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dailyBackups returns a backup per day, from oldest to newest
+func dailyBackups(start time.Time, days int) []string {
+	var files []string
+	for i := range days {
+		files = append(files, fmt.Sprintf("db/test-%s.sql", start.AddDate(0, 0, i).Format("20060102150405")))
+	}
+
+	return files
+}
+
+func TestRetentionKeep(t *testing.T) {
+	r := require.New(t)
+
+	files := dailyBackups(time.Date(2025, 1, 1, 3, 0, 0, 0, time.Local), 5)
+
+	r.Equal(files[:3], RetentionPolicy{Keep: 2}.expired(files), "the oldest backups should be removed")
+	r.Empty(RetentionPolicy{Keep: 5}.expired(files), "nothing should be removed under the limit")
+	r.Empty(RetentionPolicy{}.expired(files), "an empty policy should keep every backup")
+
+	unordered := []string{files[4], "db/test-latest.sql", files[0], files[2]}
+	r.Equal([]string{files[0]}, RetentionPolicy{Keep: 2}.expired(unordered),
+		"backups should be sorted by timestamp and backups without one should be kept")
+}
+
+func TestRetentionGFS(t *testing.T) {
+	r := require.New(t)
+
+	// two backups a day from 2023-01-01 to 2025-03-31
+	var files []string
+	for _, file := range dailyBackups(time.Date(2023, 1, 1, 1, 0, 0, 0, time.Local), 821) {
+		files = append(files, file, file[:len("db/test-20230101")]+"130000.sql")
+	}
+
+	policy := RetentionPolicy{Daily: 7, Weekly: 4, Monthly: 12, Yearly: 3}
+	expired := policy.expired(files)
+
+	removed := map[string]bool{}
+	for _, file := range expired {
+		removed[file] = true
+	}
+
+	var kept []string
+	for _, file := range files {
+		if !removed[file] {
+			kept = append(kept, file)
+		}
+	}
+
+	r.Equal([]string{
+		"db/test-20231231130000.sql", // yearly
+		"db/test-20240430130000.sql", // monthly
+		"db/test-20240531130000.sql",
+		"db/test-20240630130000.sql",
+		"db/test-20240731130000.sql",
+		"db/test-20240831130000.sql",
+		"db/test-20240930130000.sql",
+		"db/test-20241031130000.sql",
+		"db/test-20241130130000.sql",
+		"db/test-20241231130000.sql", // monthly and yearly
+		"db/test-20250131130000.sql",
+		"db/test-20250228130000.sql",
+		"db/test-20250316130000.sql", // weekly
+		"db/test-20250323130000.sql",
+		"db/test-20250325130000.sql", // daily
+		"db/test-20250326130000.sql",
+		"db/test-20250327130000.sql",
+		"db/test-20250328130000.sql",
+		"db/test-20250329130000.sql",
+		"db/test-20250330130000.sql", // daily and weekly
+		"db/test-20250331130000.sql", // daily, weekly (a monday), monthly and yearly
+	}, kept)
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	files := backupFiles(dailyBackups(now.AddDate(0, 0, -40), 40))
+
+	policy := RetentionPolicy{MaxAge: 35 * 24 * time.Hour, MinCount: 3}
+	expired := policy.expiredFiles(files, now)
+	r.Equal(backupNames(files[:5]), expired, "backups older than the max age should be removed")
+
+	// the last backups are too old but the minimum is kept
+	old := backupFiles(dailyBackups(now.AddDate(0, 0, -100), 5))
+	r.Equal(backupNames(old[:2]), policy.expiredFiles(old, now), "the minimum count should be kept")
+
+	// every backup is too old and no minimum is configured
+	policy = RetentionPolicy{MaxAge: 35 * 24 * time.Hour}
+	r.Equal(backupNames(old[:4]), policy.expiredFiles(old, now), "the most recent backup should never be removed")
+
+	// the max age is applied over the other rules
+	policy = RetentionPolicy{Keep: 40, Monthly: 12, MaxAge: 35 * 24 * time.Hour}
+	r.Equal(backupNames(files[:5]), policy.expiredFiles(files, now), "max age should override the other rules")
+
+	policy = RetentionPolicy{Keep: 2, MaxAge: 35 * 24 * time.Hour}
+	r.Len(policy.expiredFiles(files, now), 38, "count rule should still apply to recent backups")
+}
+
+func TestRetentionModTime(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	files := []backupFile{
+		{name: "db/test-20250230000000.sql", modTime: now.AddDate(0, 0, -50)},
+		{name: "db/test-20250231000000.sql"},
+		{name: "db/test-20250228000000.sql", modTime: now.AddDate(0, 0, -50)},
+	}
+
+	policy := RetentionPolicy{MaxAge: 35 * 24 * time.Hour}
+	r.Equal([]string{"db/test-20250230000000.sql"}, policy.expiredFiles(files, now),
+		"the modification time should be used if the timestamp is invalid")
+}
+
+func TestRetentionMaxSize(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	files := backupFiles(dailyBackups(now.AddDate(0, 0, -5), 5))
+	for i := range files {
+		files[i].size = 100
+	}
+
+	policy := RetentionPolicy{Keep: 5, MaxSize: 250}
+	r.Equal(backupNames(files[:3]), policy.expiredFiles(files, now), "the oldest backups should be removed until under the quota")
+
+	policy = RetentionPolicy{MaxSize: 300}
+	r.Equal(backupNames(files[:2]), policy.expiredFiles(files, now), "the quota should work without other rules")
+
+	files[4].size = 1000
+	r.Equal(backupNames(files[:4]), policy.expiredFiles(files, now), "the most recent backup should never be removed")
+
+	policy = RetentionPolicy{MaxSize: 300, MinCount: 2}
+	r.Equal(backupNames(files[:3]), policy.expiredFiles(files, now), "the minimum count should be kept")
+}
+
+func TestRetentionMaxStoreSize(t *testing.T) {
+	r := require.New(t)
+
+	files := []backupFile{
+		{name: "app/app-20250101000000.sql", size: 100},
+		{name: "app/app-20250102000000.sql", size: 100},
+		{name: "app/app-20250103000000.sql", size: 100},
+		{name: "users/2025/01/users-20250101120000.sql", size: 100},
+		{name: "users/2025/02/users-20250201120000.sql", size: 100},
+		{name: "users/2025/02/users-20250202120000.sql", size: 100},
+		{name: "big/big-20250101060000.sql", size: 500},
+	}
+
+	r.Empty(RetentionPolicy{}.expiredByStoreSize(files), "the store quota should be disabled by default")
+
+	policy := RetentionPolicy{MaxStoreSize: 800}
+	r.Equal([]string{
+		"app/app-20250101000000.sql",
+		"users/2025/01/users-20250101120000.sql",
+		"app/app-20250102000000.sql",
+	}, policy.expiredByStoreSize(files), "the oldest backups of the store should be removed")
+
+	policy = RetentionPolicy{MaxStoreSize: 100}
+	r.Len(policy.expiredByStoreSize(files), 4, "the most recent backup of each prefix should be kept")
+}
+
+func TestRetentionSizeWithoutSizes(t *testing.T) {
+	r := require.New(t)
+
+	names := dailyBackups(time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local), 5)
+
+	policy := RetentionPolicy{Keep: 3, MaxSize: 1, MaxStoreSize: 1}
+	r.Equal(names[:2], policy.expired(names), "the quotas should be ignored on stores without sizes")
+}
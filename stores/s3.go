@@ -17,44 +17,412 @@ limitations under the License.
 package stores
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 // S3Config has the config options for the S3 service
 type S3Config struct {
-	Endpoint        string
-	Region          string
-	Bucket          string
-	Prefix          string
-	ForcePathStyle  bool
-	KeepAfterUpload bool
-	SaveDir         string
-	retrievedFile   string
-}
-
-func (s *S3Config) newSession() *session.Session {
-	config := &aws.Config{
+	Endpoint              string
+	Region                string
+	Bucket                string
+	Prefix                string
+	ForcePathStyle        bool
+	AccessKey             string
+	SecretKey             string
+	SessionToken          string
+	Profile               string
+	SharedCredentialsFile string
+	RoleARN               string
+	RoleExternalID        string
+	RoleSessionName       string
+	RoleDuration          time.Duration
+	WebIdentityTokenFile  string
+	STSEndpoint           string
+	SSE                   string
+	SSEKMSKeyID           string
+	SSEKMSContext         map[string]string
+	SSECustomerKey        string
+	StorageClass          string
+	RestoreDays           int64
+	RestoreTier           string
+	RestoreTimeout        time.Duration
+	RestorePollInterval   time.Duration
+	ObjectLockMode        string
+	ObjectLockDays        int
+	Tags                  []string
+	VersionID             string
+	ResumableUpload       bool
+	PartSize              int64
+	UploadRetries         int
+	StaleUploadAge        time.Duration
+	CAFile                string
+	ClientCertFile        string
+	ClientKeyFile         string
+	ProxyURL              string
+	InsecureSkipVerify    bool
+	KeepAfterUpload       bool
+	SaveDir               string
+	sessionOnce           sync.Once
+	sess                  *session.Session
+	sessErr               error
+	retrievedFile         string
+}
+
+const sseCustomerAlgorithm = "AES256"
+
+// checksumMetadataKey is the user metadata where the SHA-256 of the backup is stored
+const checksumMetadataKey = "Sha256"
+
+// customerKey reads the SSE-C key file, it can contain the raw 256-bit key or its base64 encoding
+func (s *S3Config) customerKey() (string, error) {
+	data, err := os.ReadFile(s.SSECustomerKey)
+	if err != nil {
+		return "", fmt.Errorf("cannot read SSE-C key file %s, %v", s.SSECustomerKey, err)
+	}
+
+	if len(data) == 32 {
+		return string(data), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != 32 {
+		return "", fmt.Errorf("SSE-C key on %s must be 32 bytes long (raw or base64 encoded)", s.SSECustomerKey)
+	}
+
+	return string(key), nil
+}
+
+// applyEncryption sets the server-side encryption options on the upload
+func (s *S3Config) applyEncryption(input *s3manager.UploadInput) error {
+	if s.SSECustomerKey != "" {
+		if s.SSE != "" || s.SSEKMSKeyID != "" {
+			return fmt.Errorf("SSE-C cannot be combined with SSE-S3 or SSE-KMS")
+		}
+
+		key, err := s.customerKey()
+		if err != nil {
+			return err
+		}
+
+		input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		input.SSECustomerKey = aws.String(key)
+
+		return nil
+	}
+
+	sse := s.SSE
+	if sse == "" && s.SSEKMSKeyID != "" {
+		sse = s3.ServerSideEncryptionAwsKms
+	}
+
+	switch sse {
+	case "":
+		return nil
+	case s3.ServerSideEncryptionAes256:
+		input.ServerSideEncryption = aws.String(sse)
+	case s3.ServerSideEncryptionAwsKms:
+		input.ServerSideEncryption = aws.String(sse)
+		if s.SSEKMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.SSEKMSKeyID)
+		}
+
+		if len(s.SSEKMSContext) > 0 {
+			data, err := json.Marshal(s.SSEKMSContext)
+			if err != nil {
+				return fmt.Errorf("cannot encode the SSE-KMS encryption context, %v", err)
+			}
+
+			input.SSEKMSEncryptionContext = aws.String(base64.StdEncoding.EncodeToString(data))
+		}
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q, use %s or %s",
+			sse, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+
+	return nil
+}
+
+// httpClient returns the client used to connect to the endpoints, nil if the default one can be used
+func (s *S3Config) httpClient() (*http.Client, error) {
+	if s.CAFile == "" && s.ClientCertFile == "" && s.ClientKeyFile == "" && s.ProxyURL == "" && !s.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	// the SDK loads the CA bundle and client certificate on the transport of this client
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if s.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled for the S3 store")
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if s.ProxyURL != "" {
+		proxy, err := url.Parse(s.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %s, %v", s.ProxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// tlsOptions sets the CA bundle and client certificate of the session, overriding the ones
+// set on the environment
+func (s *S3Config) tlsOptions(options *session.Options) error {
+	if s.CAFile != "" {
+		ca, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return fmt.Errorf("cannot read CA file %s, %v", s.CAFile, err)
+		}
+		options.CustomCABundle = bytes.NewReader(ca)
+	}
+
+	if s.ClientCertFile == "" && s.ClientKeyFile == "" {
+		return nil
+	}
+
+	if s.ClientCertFile == "" || s.ClientKeyFile == "" {
+		return fmt.Errorf("both the client certificate and the client key are needed")
+	}
+
+	cert, err := os.ReadFile(s.ClientCertFile)
+	if err != nil {
+		return fmt.Errorf("cannot read client certificate %s, %v", s.ClientCertFile, err)
+	}
+
+	key, err := os.ReadFile(s.ClientKeyFile)
+	if err != nil {
+		return fmt.Errorf("cannot read client key %s, %v", s.ClientKeyFile, err)
+	}
+
+	options.ClientTLSCert = bytes.NewReader(cert)
+	options.ClientTLSKey = bytes.NewReader(key)
+
+	return nil
+}
+
+func (s *S3Config) newSession() (*session.Session, error) {
+	client, err := s.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	config := aws.Config{
 		Endpoint:         aws.String(s.Endpoint),
 		Region:           aws.String(s.Region),
 		S3ForcePathStyle: aws.Bool(s.ForcePathStyle),
+		HTTPClient:       client,
+	}
+
+	// static keys have precedence over the profile and the default chain
+	if s.AccessKey != "" || s.SecretKey != "" {
+		config.Credentials = credentials.NewStaticCredentials(s.AccessKey, s.SecretKey, s.SessionToken)
+	}
+
+	options := session.Options{
+		Config:            config,
+		Profile:           s.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+
+	if s.SharedCredentialsFile != "" {
+		options.SharedConfigFiles = []string{s.SharedCredentialsFile}
+	}
+
+	if err = s.tlsOptions(&options); err != nil {
+		return nil, err
+	}
+
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create S3 session, %v", err)
+	}
+
+	if s.RoleARN == "" {
+		return sess, nil
+	}
+
+	// the S3 endpoint must not be used for the STS requests
+	stsSession := sess.Copy(&aws.Config{Endpoint: aws.String(s.STSEndpoint)})
+
+	var creds *credentials.Credentials
+	if s.WebIdentityTokenFile != "" {
+		creds = stscreds.NewWebIdentityCredentials(stsSession, s.RoleARN, s.RoleSessionName, s.WebIdentityTokenFile)
+	} else {
+		creds = stscreds.NewCredentials(stsSession, s.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if s.RoleExternalID != "" {
+				p.ExternalID = aws.String(s.RoleExternalID)
+			}
+
+			if s.RoleSessionName != "" {
+				p.RoleSessionName = s.RoleSessionName
+			}
+
+			if s.RoleDuration > 0 {
+				p.Duration = s.RoleDuration
+			}
+		})
 	}
 
-	return session.Must(session.NewSession(config))
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
+
+// applyObjectLock sets the retention of the uploaded object, the bucket must have Object Lock enabled
+func (s *S3Config) applyObjectLock(input *s3manager.UploadInput, now time.Time) error {
+	if s.ObjectLockMode == "" {
+		return nil
+	}
+
+	mode := strings.ToUpper(s.ObjectLockMode)
+	if mode != s3.ObjectLockModeGovernance && mode != s3.ObjectLockModeCompliance {
+		return fmt.Errorf("unsupported object lock mode %q, use %s or %s",
+			s.ObjectLockMode, s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance)
+	}
+
+	if s.ObjectLockDays <= 0 {
+		return fmt.Errorf("object lock mode %s needs a retention period of at least one day", mode)
+	}
+
+	input.ObjectLockMode = aws.String(mode)
+	input.ObjectLockRetainUntilDate = aws.Time(now.AddDate(0, 0, s.ObjectLockDays))
+
+	return nil
+}
+
+// sharedSession returns the session used by every request of the store, so the credentials
+// are only resolved once
+func (s *S3Config) sharedSession() (*session.Session, error) {
+	s.sessionOnce.Do(func() {
+		s.sess, s.sessErr = s.newSession()
+	})
+
+	return s.sess, s.sessErr
+}
+
+// encryption returns the server-side encryption used on the uploads
+func (s *S3Config) encryption() string {
+	switch {
+	case s.SSECustomerKey != "":
+		return "SSE-C"
+	case s.SSE != "":
+		return s.SSE
+	case s.SSEKMSKeyID != "":
+		return s3.ServerSideEncryptionAwsKms
+	default:
+		return "none"
+	}
+}
+
+// objectMetadata returns the user metadata saved with each backup, the checksum is one of the
+// details since the services compute it while writing the backup
+func (s *S3Config) objectMetadata(details map[string]string, size int64) map[string]string {
+	metadata := map[string]string{}
+	for k, v := range details {
+		if v != "" {
+			metadata[k] = v
+		}
+	}
+
+	metadata["encryption"] = s.encryption()
+	metadata["size"] = strconv.FormatInt(size, 10)
+
+	return metadata
+}
+
+// objectTags returns the URL encoded tag set of the object. Each configured tag is either
+// a static key=value pair or the name of a metadata key whose value is copied.
+func (s *S3Config) objectTags(metadata map[string]string) string {
+	tags := url.Values{}
+
+	for _, tag := range s.Tags {
+		key, value, found := strings.Cut(tag, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		if !found {
+			value = metadata[key]
+		}
+
+		if value != "" {
+			tags.Set(key, strings.TrimSpace(value))
+		}
+	}
+
+	return tags.Encode()
+}
+
+// uploadInput returns the upload parameters of a backup
+func (s *S3Config) uploadInput(f *os.File, key string, metadata map[string]string) (*s3manager.UploadInput, error) {
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		Body:     f,
+		Metadata: aws.StringMap(metadata),
+	}
+
+	if tags := s.objectTags(metadata); tags != "" {
+		input.Tagging = aws.String(tags)
+	}
+
+	if err := s.applyEncryption(input); err != nil {
+		return nil, err
+	}
+
+	if s.StorageClass != "" {
+		input.StorageClass = aws.String(s.StorageClass)
+	}
+
+	if err := s.applyObjectLock(input, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return input, nil
 }
 
 // Store saves a file to a remote S3 service
 func (s *S3Config) Store(filepath, prefix, filename string) error {
-	uploader := s3manager.NewUploader(s.newSession())
+	return s.StoreWithMetadata(filepath, prefix, filename, nil)
+}
+
+// StoreWithMetadata saves a file to a remote S3 service, the details of the backup are saved as object metadata
+func (s *S3Config) StoreWithMetadata(filepath, prefix, filename string, details map[string]string) error {
+	sess, err := s.sharedSession()
+	if err != nil {
+		return err
+	}
+
+	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		if s.PartSize > 0 {
+			u.PartSize = s.PartSize
+		}
+	})
 
 	f, err := os.Open(filepath)
 	if err != nil {
@@ -68,8 +436,17 @@ func (s *S3Config) Store(filepath, prefix, filename string) error {
 		}
 	}(f)
 
+	uploaded := false
+
 	if !s.KeepAfterUpload {
 		defer func() {
+			// failed resumable uploads need the file to continue later, but only the
+			// multipart uploads save a state that lets the next run find it
+			if s.ResumableUpload && !uploaded && hasUploadState(filepath) {
+				slog.Info("Keeping source file to resume the upload", "path", filepath)
+				return
+			}
+
 			slog.Info("Removing source file", "path", filepath)
 			if err = os.Remove(filepath); err != nil {
 				slog.Warn("Cannot remove file", "path", filepath, "error", err)
@@ -79,23 +456,47 @@ func (s *S3Config) Store(filepath, prefix, filename string) error {
 
 	key := path.Clean(path.Join(s.Prefix, prefix, filename))
 
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat file %q, %v", filepath, err)
+	}
+
+	metadata := s.objectMetadata(details, info.Size())
+	checksum := metadata[strings.ToLower(checksumMetadataKey)]
+
+	input, err := s.uploadInput(f, key, metadata)
+	if err != nil {
+		return err
+	}
+
+	if s.ResumableUpload && info.Size() > s.partSize(info.Size()) {
+		svc := s3.New(sess)
+		s.abortStaleUploads(svc, path.Dir(key)+"/")
+
+		if err = s.resumableUpload(svc, f, filepath, input); err != nil {
+			return fmt.Errorf("failed to upload file, %v", err)
+		}
+
+		uploaded = true
+		slog.Debug("File uploaded", "key", key, "sha256", checksum)
+
+		return nil
+	}
+
 	// Upload the file to S3.
-	res, err := uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(key),
-		Body:   f,
-	})
+	res, err := uploader.Upload(input)
 	if err != nil {
 		return fmt.Errorf("failed to upload file, %v", err)
 	}
 
-	slog.Debug("File uploaded", "location", res.Location)
+	uploaded = true
+	slog.Debug("File uploaded", "location", res.Location, "sha256", checksum)
 
 	return nil
 }
 
-func (s *S3Config) getFileListing(basedir, namePrefix string, svc *s3.S3) ([]string, error) {
-	var files []string
+func (s *S3Config) getFileListing(basedir, namePrefix string, svc s3iface.S3API) ([]backupFile, error) {
+	var files []backupFile
 	re := generatePattern(namePrefix)
 
 	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
@@ -107,7 +508,11 @@ func (s *S3Config) getFileListing(basedir, namePrefix string, svc *s3.S3) ([]str
 			if !strings.HasSuffix(aws.StringValue(obj.Key), "/") {
 				// ignore files not created by this program
 				if re.MatchString(path.Base(aws.StringValue(obj.Key))) {
-					files = append(files, aws.StringValue(obj.Key))
+					files = append(files, backupFile{
+						name:    aws.StringValue(obj.Key),
+						modTime: aws.TimeValue(obj.LastModified),
+						size:    aws.Int64Value(obj.Size),
+					})
 				}
 			}
 		}
@@ -117,10 +522,46 @@ func (s *S3Config) getFileListing(basedir, namePrefix string, svc *s3.S3) ([]str
 	return files, err
 }
 
+// isLocked returns true if the object is protected by an Object Lock retention or a legal hold
+func isLocked(out *s3.HeadObjectOutput, now time.Time) bool {
+	if aws.StringValue(out.ObjectLockLegalHoldStatus) == s3.ObjectLockLegalHoldStatusOn {
+		return true
+	}
+
+	return out.ObjectLockRetainUntilDate != nil && out.ObjectLockRetainUntilDate.After(now)
+}
+
+// headInput returns the parameters needed to read the metadata of an object
+func (s *S3Config) headInput(key string) (*s3.HeadObjectInput, error) {
+	head := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+
+	if s.SSECustomerKey != "" {
+		customerKey, err := s.customerKey()
+		if err != nil {
+			return nil, err
+		}
+
+		head.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		head.SSECustomerKey = aws.String(customerKey)
+	}
+
+	return head, nil
+}
+
 // RemoveOlderBackups keeps the most recent backups of the S3 service and deletes the old ones
-func (s *S3Config) RemoveOlderBackups(basedir, namePrefix string, keep int) error {
-	svc := s3.New(s.newSession())
+func (s *S3Config) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	sess, err := s.sharedSession()
+	if err != nil {
+		return err
+	}
+
+	return s.removeOlderBackups(s3.New(sess), basedir, namePrefix, policy)
+}
 
+func (s *S3Config) removeOlderBackups(svc s3iface.S3API, basedir, namePrefix string, policy RetentionPolicy) error {
 	files, err := s.getFileListing(basedir, namePrefix, svc)
 	if err != nil {
 		return fmt.Errorf("couldn't list S3 objects, %v", err)
@@ -130,37 +571,212 @@ func (s *S3Config) RemoveOlderBackups(basedir, namePrefix string, keep int) erro
 		return nil
 	}
 
-	sort.Strings(files)
-	count := len(files) - keep
+	if err = s.deleteObjects(svc, policy.expiredFiles(files, time.Now())); err != nil {
+		return err
+	}
+
+	if policy.MaxStoreSize <= 0 {
+		return nil
+	}
+
+	// the whole store is listed again to apply its quota
+	files, err = s.listAllBackups(svc)
+	if err != nil {
+		return fmt.Errorf("couldn't list S3 objects, %v", err)
+	}
 
-	if count > 0 {
-		var items s3.Delete
-		objs := make([]*s3.ObjectIdentifier, count)
+	return s.deleteObjects(svc, policy.expiredByStoreSize(files))
+}
 
-		for i, file := range files[:count] {
-			objs[i] = &s3.ObjectIdentifier{Key: aws.String(file)}
-			slog.Debug("Marked to delete", "bucket", s.Bucket, "file", file)
+// listAllBackups returns the backups of every prefix of the store
+func (s *S3Config) listAllBackups(svc s3iface.S3API) ([]backupFile, error) {
+	var files []backupFile
+
+	// make sure that the prefix ends with "/"
+	prefix := path.Clean(s.Prefix) + "/"
+	if prefix == "./" || prefix == "//" {
+		prefix = ""
+	}
+
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(p *s3.ListObjectsV2Output, last bool) (shouldContinue bool) {
+		for _, obj := range p.Contents {
+			key := aws.StringValue(obj.Key)
+			// ignore files not created by this program
+			if !strings.HasSuffix(key, "/") && anyBackupPattern.MatchString(path.Base(key)) {
+				files = append(files, backupFile{
+					name:    key,
+					modTime: aws.TimeValue(obj.LastModified),
+					size:    aws.Int64Value(obj.Size),
+				})
+			}
 		}
+		return true
+	})
 
-		items.SetObjects(objs)
+	return files, err
+}
 
-		out, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
-			Bucket: aws.String(s.Bucket),
-			Delete: &items,
-		})
+// deleteObjects removes the objects from the bucket, except the locked ones
+func (s *S3Config) deleteObjects(svc s3iface.S3API, expired []string) error {
+	if len(expired) == 0 {
+		return nil
+	}
+
+	var items s3.Delete
+	var objs []*s3.ObjectIdentifier
+	var locked []string
+	now := time.Now()
+
+	for _, file := range expired {
+		head, err := s.headInput(file)
 		if err != nil {
-			return fmt.Errorf("couldn't delete the S3 objects, %v", err)
+			return err
 		}
 
-		slog.Debug("Deleted objects from S3", "count", len(out.Deleted))
+		// locked objects cannot be removed so leave them out of the batch
+		out, err := svc.HeadObject(head)
+		if err != nil {
+			slog.Warn("Cannot get metadata of S3 object", "bucket", s.Bucket, "file", file, "error", err)
+		} else if isLocked(out, now) {
+			slog.Debug("Object is locked, skipping", "bucket", s.Bucket, "file", file,
+				"retain_until", aws.TimeValue(out.ObjectLockRetainUntilDate),
+				"legal_hold", aws.StringValue(out.ObjectLockLegalHoldStatus))
+			locked = append(locked, file)
+			continue
+		}
+
+		objs = append(objs, &s3.ObjectIdentifier{Key: aws.String(file)})
+		slog.Debug("Marked to delete", "bucket", s.Bucket, "file", file)
+	}
+
+	if len(locked) > 0 {
+		slog.Warn("Skipped removal of locked S3 objects", "count", len(locked), "files", locked)
+	}
+
+	if len(objs) == 0 {
+		return nil
+	}
+
+	items.SetObjects(objs)
+
+	out, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(s.Bucket),
+		Delete: &items,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't delete the S3 objects, %v", err)
+	}
+
+	for _, e := range out.Errors {
+		slog.Error("Failed to remove S3 object", "file", aws.StringValue(e.Key),
+			"code", aws.StringValue(e.Code), "error", aws.StringValue(e.Message))
 	}
 
+	slog.Debug("Deleted objects from S3", "count", len(out.Deleted))
+
 	return nil
 }
 
+// ObjectVersion is a version of a backup on a versioned bucket
+type ObjectVersion struct {
+	Key          string
+	VersionID    string
+	LastModified time.Time
+	Size         int64
+	IsLatest     bool
+	DeleteMarker bool
+}
+
+// ListVersions returns every version of a backup key, or of all the backups with the name prefix
+// if the key is empty. Deleted backups are included as their delete markers and older versions.
+func (s *S3Config) ListVersions(key, namePrefix string) ([]ObjectVersion, error) {
+	sess, err := s.sharedSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.listVersions(s3.New(sess), key, namePrefix)
+}
+
+func (s *S3Config) listVersions(svc s3iface.S3API, key, namePrefix string) ([]ObjectVersion, error) {
+	var versions []ObjectVersion
+	re := generatePattern(namePrefix)
+
+	prefix := key
+	if prefix == "" && s.Prefix != "" {
+		// make sure that the prefix ends with "/"
+		prefix = path.Clean(s.Prefix) + "/"
+	}
+
+	matches := func(objectKey string) bool {
+		if key != "" {
+			return objectKey == key
+		}
+		// ignore files not created by this program
+		return re.MatchString(path.Base(objectKey))
+	}
+
+	err := svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(p *s3.ListObjectVersionsOutput, last bool) (shouldContinue bool) {
+		for _, v := range p.Versions {
+			if matches(aws.StringValue(v.Key)) {
+				versions = append(versions, ObjectVersion{
+					Key:          aws.StringValue(v.Key),
+					VersionID:    aws.StringValue(v.VersionId),
+					LastModified: aws.TimeValue(v.LastModified),
+					Size:         aws.Int64Value(v.Size),
+					IsLatest:     aws.BoolValue(v.IsLatest),
+				})
+			}
+		}
+
+		for _, m := range p.DeleteMarkers {
+			if matches(aws.StringValue(m.Key)) {
+				versions = append(versions, ObjectVersion{
+					Key:          aws.StringValue(m.Key),
+					VersionID:    aws.StringValue(m.VersionId),
+					LastModified: aws.TimeValue(m.LastModified),
+					IsLatest:     aws.BoolValue(m.IsLatest),
+					DeleteMarker: true,
+				})
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list S3 object versions, %v", err)
+	}
+
+	// newest versions first
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key > versions[j].Key
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+
+	return versions, nil
+}
+
 // FindLatestBackup returns the most recent backup of the S3 store
 func (s *S3Config) FindLatestBackup(basedir, namePrefix string) (string, error) {
-	svc := s3.New(s.newSession())
+	// a version belongs to a single object, it cannot be applied to the latest backup
+	if s.VersionID != "" {
+		return "", fmt.Errorf("the S3 version id can only be used together with the restore file")
+	}
+
+	sess, err := s.sharedSession()
+	if err != nil {
+		return "", err
+	}
+
+	svc := s3.New(sess)
 
 	files, err := s.getFileListing(basedir, namePrefix, svc)
 	if err != nil {
@@ -172,15 +788,173 @@ func (s *S3Config) FindLatestBackup(basedir, namePrefix string) (string, error)
 			s.Bucket, s.Prefix)
 	}
 
-	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	names := backupNames(files)
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	return names[0], nil
+}
+
+// isArchived returns true if objects of the storage class must be restored before downloading them
+func isArchived(storageClass string) bool {
+	return storageClass == s3.StorageClassGlacier || storageClass == s3.StorageClassDeepArchive
+}
+
+// isRestored returns true if the x-amz-restore header shows a finished restore
+func isRestored(restore string) bool {
+	return strings.Contains(restore, `ongoing-request="false"`)
+}
+
+// waitForRestore requests a temporary copy of an archived object and waits until it can be downloaded.
+// Returns the metadata of the object.
+func (s *S3Config) waitForRestore(svc s3iface.S3API, head *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	key := aws.StringValue(head.Key)
+
+	out, err := svc.HeadObject(head)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get metadata of S3 object %s, %v", key, err)
+	}
+
+	if !isArchived(aws.StringValue(out.StorageClass)) || isRestored(aws.StringValue(out.Restore)) {
+		return out, nil
+	}
+
+	// the header is missing if no restore was requested yet
+	if out.Restore == nil {
+		days := s.RestoreDays
+		if days <= 0 {
+			days = 1
+		}
+
+		tier := s.RestoreTier
+		if tier == "" {
+			tier = s3.TierStandard
+		}
+
+		slog.Info("Object is archived, requesting a restore", "key", key,
+			"storage_class", aws.StringValue(out.StorageClass), "tier", tier, "days", days)
+
+		_, err = svc.RestoreObject(&s3.RestoreObjectInput{
+			Bucket:    head.Bucket,
+			Key:       head.Key,
+			VersionId: head.VersionId,
+			RestoreRequest: &s3.RestoreRequest{
+				Days:                 aws.Int64(days),
+				GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+			},
+		})
+		if err != nil {
+			var awsErr awserr.Error
+			if !errors.As(err, &awsErr) || awsErr.Code() != "RestoreAlreadyInProgress" {
+				return nil, fmt.Errorf("cannot restore archived S3 object %s, %v", key, err)
+			}
+		}
+	}
+
+	interval := s.RestorePollInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	// a nil channel blocks forever so a zero timeout waits until the restore finishes
+	var timeout <-chan time.Time
+	if s.RestoreTimeout > 0 {
+		timer := time.NewTimer(s.RestoreTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timeout:
+			return nil, fmt.Errorf("timed out waiting for the restore of S3 object %s", key)
+		case <-ticker.C:
+		}
+
+		out, err = svc.HeadObject(head)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get metadata of S3 object %s, %v", key, err)
+		}
+
+		if isRestored(aws.StringValue(out.Restore)) {
+			slog.Info("Archived object restored", "key", key)
+			return out, nil
+		}
+
+		slog.Debug("Waiting for the restore of the archived object", "key", key)
+	}
+}
+
+// metadataValue returns the value of an user metadata key, the case of the returned keys depends on the service
+func metadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+
+	return ""
+}
+
+// verifyChecksum compares the SHA-256 of the downloaded file with the one stored on upload
+func verifyChecksum(filepath string, metadata map[string]*string) error {
+	expected := metadataValue(metadata, checksumMetadataKey)
+	if expected == "" {
+		slog.Warn("Backup has no checksum, skipping verification", "path", filepath)
+		return nil
+	}
+
+	actual, err := fileChecksum(filepath)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(expected, actual) {
+		return fmt.Errorf("checksum mismatch on %s, expected SHA-256 %s but got %s", path.Base(filepath), expected, actual)
+	}
+
+	slog.Debug("Checksum verified", "path", filepath, "sha256", actual)
 
-	return files[0], nil
+	return nil
 }
 
 // Retrieve downloads a S3 object to the local filesystem
 func (s *S3Config) Retrieve(s3path string) (string, error) {
+	sess, err := s.sharedSession()
+	if err != nil {
+		return "", err
+	}
+
 	// Create an uploader with the session and default options
-	downloader := s3manager.NewDownloader(s.newSession())
+	downloader := s3manager.NewDownloader(sess)
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s3path),
+	}
+
+	head, err := s.headInput(s3path)
+	if err != nil {
+		return "", err
+	}
+
+	// objects encrypted with SSE-C need the same key to be downloaded
+	input.SSECustomerAlgorithm = head.SSECustomerAlgorithm
+	input.SSECustomerKey = head.SSECustomerKey
+
+	// older versions are still available after the object is overwritten or deleted
+	if s.VersionID != "" {
+		slog.Info("Retrieving object version", "key", s3path, "version", s.VersionID)
+		input.VersionId = aws.String(s.VersionID)
+		head.VersionId = aws.String(s.VersionID)
+	}
+
+	metadata, err := s.waitForRestore(s3.New(sess), head)
+	if err != nil {
+		return "", err
+	}
 
 	filepath := path.Join(s.SaveDir, path.Base(s3path))
 	f, err := os.Create(filepath)
@@ -191,20 +965,70 @@ func (s *S3Config) Retrieve(s3path string) (string, error) {
 	defer f.Close()
 
 	// download the file from S3.
-	_, err = downloader.Download(f, &s3.GetObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(s3path),
-	})
+	_, err = downloader.Download(f, input)
 	if err != nil {
+		removePartialDownload(filepath)
 		return "", fmt.Errorf("failed to download S3 object, %v", err)
 	}
 
+	if err = verifyChecksum(filepath, metadata.Metadata); err != nil {
+		removePartialDownload(filepath)
+		return "", err
+	}
+
 	slog.Debug("File downloaded", "location", filepath)
 	s.retrievedFile = filepath
 
 	return filepath, nil
 }
 
+// Presign returns a time-limited url to download a S3 object without credentials
+func (s *S3Config) Presign(s3path string, expires time.Duration) (string, error) {
+	if s.SSECustomerKey != "" {
+		return "", fmt.Errorf("cannot presign objects encrypted with SSE-C, the key would be needed to download them")
+	}
+
+	sess, err := s.sharedSession()
+	if err != nil {
+		return "", err
+	}
+
+	svc := s3.New(sess)
+
+	head, err := s.headInput(s3path)
+	if err != nil {
+		return "", err
+	}
+
+	if s.VersionID != "" {
+		head.VersionId = aws.String(s.VersionID)
+	}
+
+	// fail early instead of handing out an url that doesn't work
+	out, err := svc.HeadObject(head)
+	if err != nil {
+		return "", fmt.Errorf("cannot get metadata of S3 object %s, %v", s3path, err)
+	}
+
+	if isArchived(aws.StringValue(out.StorageClass)) && !isRestored(aws.StringValue(out.Restore)) {
+		return "", fmt.Errorf("S3 object %s is archived on %s, it must be restored first",
+			s3path, aws.StringValue(out.StorageClass))
+	}
+
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket:    aws.String(s.Bucket),
+		Key:       aws.String(s3path),
+		VersionId: head.VersionId,
+	})
+
+	signed, err := req.Presign(expires)
+	if err != nil {
+		return "", fmt.Errorf("cannot presign S3 object %s, %v", s3path, err)
+	}
+
+	return signed, nil
+}
+
 // Close deinitializes the store (remove downloaded file)
 func (s *S3Config) Close() {
 	if s.retrievedFile != "" {
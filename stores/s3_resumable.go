@@ -0,0 +1,501 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// defaultPartSize is the part size of the resumable uploads if none is configured
+const defaultPartSize = 64 * 1024 * 1024
+
+// resumableRetryDelay is the base time to wait before retrying a failed upload
+var resumableRetryDelay = 5 * time.Second
+
+// uploadStateSuffix is appended to the name of the uploaded file to get the name of its state
+const uploadStateSuffix = ".upload.json"
+
+// uploadState is saved next to the uploaded file so an interrupted multipart upload can be resumed
+type uploadState struct {
+	Bucket   string            `json:"bucket"`
+	Key      string            `json:"key"`
+	UploadID string            `json:"upload_id"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"mod_time"`
+	PartSize int64             `json:"part_size"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Parts    []uploadPart      `json:"parts"`
+}
+
+type uploadPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+func uploadStatePath(filepath string) string {
+	return filepath + uploadStateSuffix
+}
+
+func readUploadState(filepath string) (*uploadState, error) {
+	data, err := os.ReadFile(uploadStatePath(filepath))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read upload state, %v", err)
+	}
+
+	var state uploadState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("cannot parse upload state, %v", err)
+	}
+
+	return &state, nil
+}
+
+func writeUploadState(filepath string, state *uploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("cannot encode upload state, %v", err)
+	}
+
+	// write to a temporary file first so a crash cannot leave a truncated state
+	tmp := uploadStatePath(filepath) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write upload state, %v", err)
+	}
+
+	if err = os.Rename(tmp, uploadStatePath(filepath)); err != nil {
+		return fmt.Errorf("cannot write upload state, %v", err)
+	}
+
+	return nil
+}
+
+// hasUploadState returns true if the file was kept to resume its upload
+func hasUploadState(filepath string) bool {
+	_, err := os.Stat(uploadStatePath(filepath))
+	return err == nil
+}
+
+func removeUploadState(filepath string) {
+	if err := os.Remove(uploadStatePath(filepath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Cannot remove upload state", "path", uploadStatePath(filepath), "error", err)
+	}
+}
+
+func (s *S3Config) partSize(size int64) int64 {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+
+	if partSize < s3manager.MinUploadPartSize {
+		partSize = s3manager.MinUploadPartSize
+	}
+
+	// S3 doesn't allow more than 10000 parts
+	if size/partSize >= s3manager.MaxUploadParts {
+		partSize = size/s3manager.MaxUploadParts + 1
+	}
+
+	return partSize
+}
+
+// isNoSuchUpload returns true if the multipart upload was completed or aborted
+func isNoSuchUpload(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchUpload
+}
+
+// resumeUploadState returns the saved state of the file if it belongs to the same upload. The parts
+// are refreshed from S3 in case the state file is behind.
+func (s *S3Config) resumeUploadState(svc s3iface.S3API, filepath string, info os.FileInfo, key string, partSize int64) *uploadState {
+	state, err := readUploadState(filepath)
+	if err != nil {
+		slog.Warn("Ignoring upload state", "path", uploadStatePath(filepath), "error", err)
+		return nil
+	}
+
+	if state == nil {
+		return nil
+	}
+
+	if state.Bucket != s.Bucket || state.Key != key || state.Size != info.Size() ||
+		!state.ModTime.Equal(info.ModTime()) || state.PartSize != partSize {
+		slog.Info("Upload state doesn't match the file, starting a new upload", "path", uploadStatePath(filepath))
+		return nil
+	}
+
+	var parts []uploadPart
+	err = svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	}, func(p *s3.ListPartsOutput, last bool) bool {
+		for _, part := range p.Parts {
+			parts = append(parts, uploadPart{Number: aws.Int64Value(part.PartNumber), ETag: aws.StringValue(part.ETag)})
+		}
+		return true
+	})
+	if err != nil {
+		if !isNoSuchUpload(err) {
+			slog.Warn("Cannot list uploaded parts, starting a new upload", "key", key, "error", err)
+		}
+		return nil
+	}
+
+	state.Parts = parts
+	slog.Info("Resuming multipart upload", "key", key, "upload_id", state.UploadID, "parts", len(parts))
+
+	return state
+}
+
+// uploadParts sends the parts that are missing from the state, saving it after each one
+func (s *S3Config) uploadParts(svc s3iface.S3API, f *os.File, filepath string, input *s3manager.UploadInput, state *uploadState) error {
+	done := map[int64]bool{}
+	for _, part := range state.Parts {
+		done[part.Number] = true
+	}
+
+	count := (state.Size + state.PartSize - 1) / state.PartSize
+	if count == 0 {
+		count = 1
+	}
+
+	for number := int64(1); number <= count; number++ {
+		if done[number] {
+			continue
+		}
+
+		offset := (number - 1) * state.PartSize
+		size := min(state.PartSize, state.Size-offset)
+
+		out, err := svc.UploadPart(&s3.UploadPartInput{
+			Bucket:               aws.String(state.Bucket),
+			Key:                  aws.String(state.Key),
+			UploadId:             aws.String(state.UploadID),
+			PartNumber:           aws.Int64(number),
+			Body:                 io.NewSectionReader(f, offset, size),
+			ContentLength:        aws.Int64(size),
+			SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+			SSECustomerKey:       input.SSECustomerKey,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d of %d, %w", number, count, err)
+		}
+
+		state.Parts = append(state.Parts, uploadPart{Number: number, ETag: aws.StringValue(out.ETag)})
+		if err = writeUploadState(filepath, state); err != nil {
+			return err
+		}
+
+		slog.Debug("Uploaded part", "key", state.Key, "part", number, "total", count)
+	}
+
+	sort.Slice(state.Parts, func(i, j int) bool { return state.Parts[i].Number < state.Parts[j].Number })
+
+	completed := make([]*s3.CompletedPart, len(state.Parts))
+	for i, part := range state.Parts {
+		completed[i] = &s3.CompletedPart{PartNumber: aws.Int64(part.Number), ETag: aws.String(part.ETag)}
+	}
+
+	_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(state.Bucket),
+		Key:             aws.String(state.Key),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload, %w", err)
+	}
+
+	return nil
+}
+
+// resumableUpload uploads the file in parts, keeping track of the finished parts on a state file
+// next to it. Failed uploads are retried and the state is kept so a later upload of the same file
+// can continue where it stopped.
+func (s *S3Config) resumableUpload(svc s3iface.S3API, f *os.File, filepath string, input *s3manager.UploadInput) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat file %q, %v", filepath, err)
+	}
+
+	key := aws.StringValue(input.Key)
+	partSize := s.partSize(info.Size())
+	state := s.resumeUploadState(svc, filepath, info, key, partSize)
+
+	for attempt := 0; ; attempt++ {
+		if state == nil {
+			create := &s3.CreateMultipartUploadInput{}
+			awsutil.Copy(create, input)
+
+			out, err := svc.CreateMultipartUpload(create)
+			if err != nil {
+				return fmt.Errorf("cannot create multipart upload, %v", err)
+			}
+
+			state = &uploadState{
+				Bucket:   s.Bucket,
+				Key:      key,
+				UploadID: aws.StringValue(out.UploadId),
+				Size:     info.Size(),
+				ModTime:  info.ModTime(),
+				PartSize: partSize,
+				Metadata: aws.StringValueMap(input.Metadata),
+			}
+
+			if err = writeUploadState(filepath, state); err != nil {
+				return err
+			}
+
+			slog.Debug("Created multipart upload", "key", key, "upload_id", state.UploadID, "part_size", partSize)
+		}
+
+		err = s.uploadParts(svc, f, filepath, input, state)
+		if err == nil {
+			removeUploadState(filepath)
+			return nil
+		}
+
+		// the upload is gone, start a new one on the next attempt
+		if isNoSuchUpload(err) {
+			state = nil
+			removeUploadState(filepath)
+		}
+
+		if attempt >= s.UploadRetries {
+			return fmt.Errorf("%v, the upload state was saved on %s", err, uploadStatePath(filepath))
+		}
+
+		delay := resumableRetryDelay * time.Duration(attempt+1)
+		slog.Warn("Upload failed, retrying", "key", key, "attempt", attempt+1, "delay", delay, "error", err)
+		time.Sleep(delay)
+	}
+}
+
+// abortStaleUploads aborts the unfinished multipart uploads of the prefix that were started before the configured age
+func (s *S3Config) abortStaleUploads(svc s3iface.S3API, prefix string) {
+	if s.StaleUploadAge <= 0 {
+		return
+	}
+
+	limit := time.Now().Add(-s.StaleUploadAge)
+
+	var stale []*s3.MultipartUpload
+	err := svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(p *s3.ListMultipartUploadsOutput, last bool) bool {
+		for _, upload := range p.Uploads {
+			if aws.TimeValue(upload.Initiated).Before(limit) {
+				stale = append(stale, upload)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		slog.Warn("Cannot list multipart uploads", "bucket", s.Bucket, "prefix", prefix, "error", err)
+		return
+	}
+
+	for _, upload := range stale {
+		_, err = svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.Bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err != nil {
+			slog.Warn("Cannot abort stale multipart upload", "key", aws.StringValue(upload.Key), "error", err)
+			continue
+		}
+
+		slog.Info("Aborted stale multipart upload", "key", aws.StringValue(upload.Key),
+			"upload_id", aws.StringValue(upload.UploadId), "initiated", aws.TimeValue(upload.Initiated))
+	}
+
+	// remove the files and state kept for the aborted uploads
+	if len(stale) > 0 && s.SaveDir != "" {
+		pending, err := s.pendingUploads()
+		if err != nil {
+			slog.Warn("Cannot find the files of the aborted uploads", "error", err)
+			return
+		}
+
+		for _, source := range pending {
+			state, err := readUploadState(source)
+			if err != nil || state == nil {
+				continue
+			}
+
+			for _, upload := range stale {
+				if state.UploadID == aws.StringValue(upload.UploadId) {
+					slog.Info("Removing file of aborted upload", "path", source)
+					removeUploadFile(source)
+				}
+			}
+		}
+	}
+}
+
+// pendingUploads returns the files of the save directory that were kept to resume their upload
+func (s *S3Config) pendingUploads() ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(s.SaveDir, func(name string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() && strings.HasSuffix(name, uploadStateSuffix) {
+			files = append(files, strings.TrimSuffix(name, uploadStateSuffix))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list contents of directory %s, %v", s.SaveDir, err)
+	}
+
+	return files, nil
+}
+
+// removeUploadFile removes a file kept to resume its upload and its state
+func removeUploadFile(filepath string) {
+	removeUploadState(filepath)
+
+	if err := os.Remove(filepath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Cannot remove file", "path", filepath, "error", err)
+	}
+}
+
+// discardUpload aborts an unfinished multipart upload and removes the files kept to resume it
+func discardUpload(svc s3iface.S3API, filepath string, state *uploadState) {
+	_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	})
+	if err != nil && !isNoSuchUpload(err) {
+		slog.Warn("Cannot abort multipart upload", "key", state.Key, "upload_id", state.UploadID, "error", err)
+	}
+
+	removeUploadFile(filepath)
+}
+
+// ResumePendingUploads finishes the resumable uploads that failed on previous runs
+func (s *S3Config) ResumePendingUploads() error {
+	if !s.ResumableUpload || s.SaveDir == "" {
+		return nil
+	}
+
+	sess, err := s.sharedSession()
+	if err != nil {
+		return err
+	}
+
+	return s.resumePendingUploads(s3.New(sess))
+}
+
+// resumePendingUploads continues the uploads of the files that were kept on the save directory.
+// Files older than the stale upload age are removed instead, so uploads that keep failing cannot
+// fill the disk.
+func (s *S3Config) resumePendingUploads(svc s3iface.S3API) error {
+	pending, err := s.pendingUploads()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, source := range pending {
+		if err = s.resumePendingUpload(svc, source); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *S3Config) resumePendingUpload(svc s3iface.S3API, filepath string) error {
+	state, err := readUploadState(filepath)
+	if err != nil || state == nil {
+		return err
+	}
+
+	if state.Bucket != s.Bucket {
+		slog.Warn("Pending upload belongs to another bucket, skipping", "path", filepath, "bucket", state.Bucket)
+		return nil
+	}
+
+	info, err := os.Stat(filepath)
+	if err != nil {
+		slog.Warn("File of pending upload not found, discarding the upload", "path", filepath, "key", state.Key)
+		discardUpload(svc, filepath, state)
+		return nil
+	}
+
+	if s.StaleUploadAge > 0 && time.Since(info.ModTime()) > s.StaleUploadAge {
+		slog.Warn("Pending upload is stale, removing the file", "path", filepath, "key", state.Key, "modified", info.ModTime())
+		discardUpload(svc, filepath, state)
+		return nil
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer f.Close()
+
+	input, err := s.uploadInput(f, state.Key, state.Metadata)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Resuming pending upload", "path", filepath, "key", state.Key)
+	if err = s.resumableUpload(svc, f, filepath, input); err != nil {
+		return fmt.Errorf("failed to resume upload of %s, %v", filepath, err)
+	}
+
+	slog.Debug("File uploaded", "key", state.Key, "sha256", state.Metadata[strings.ToLower(checksumMetadataKey)])
+
+	if !s.KeepAfterUpload {
+		slog.Info("Removing source file", "path", filepath)
+		if err = os.Remove(filepath); err != nil {
+			slog.Warn("Cannot remove file", "path", filepath, "error", err)
+		}
+	}
+
+	return nil
+}
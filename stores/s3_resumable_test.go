@@ -0,0 +1,361 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/require"
+)
+
+type multipartS3Client struct {
+	s3iface.S3API
+	failPart  int64
+	created   int
+	uploaded  []int64
+	parts     map[int64]string
+	completed []*s3.CompletedPart
+	uploads   []*s3.MultipartUpload
+	aborted   []string
+}
+
+func (c *multipartS3Client) CreateMultipartUpload(_ *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	c.created++
+	c.parts = map[int64]string{}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(fmt.Sprintf("upload-%d", c.created))}, nil
+}
+
+func (c *multipartS3Client) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	number := aws.Int64Value(input.PartNumber)
+	if number == c.failPart {
+		c.failPart = 0
+		return nil, fmt.Errorf("connection reset")
+	}
+
+	if _, err := io.Copy(io.Discard, input.Body); err != nil {
+		return nil, err
+	}
+
+	c.uploaded = append(c.uploaded, number)
+	c.parts[number] = fmt.Sprintf("etag-%d", number)
+
+	return &s3.UploadPartOutput{ETag: aws.String(c.parts[number])}, nil
+}
+
+func (c *multipartS3Client) ListPartsPages(input *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool) error {
+	if aws.StringValue(input.UploadId) != fmt.Sprintf("upload-%d", c.created) {
+		return awserr.New(s3.ErrCodeNoSuchUpload, "upload not found", nil)
+	}
+
+	out := &s3.ListPartsOutput{}
+	for number, etag := range c.parts {
+		out.Parts = append(out.Parts, &s3.Part{PartNumber: aws.Int64(number), ETag: aws.String(etag)})
+	}
+	fn(out, true)
+
+	return nil
+}
+
+func (c *multipartS3Client) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	c.completed = input.MultipartUpload.Parts
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (c *multipartS3Client) ListMultipartUploadsPages(_ *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool) error {
+	fn(&s3.ListMultipartUploadsOutput{Uploads: c.uploads}, true)
+	return nil
+}
+
+func (c *multipartS3Client) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	c.aborted = append(c.aborted, aws.StringValue(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// fakeS3Server implements the object and multipart upload requests of S3 on path style urls
+type fakeS3Server struct {
+	mu       sync.Mutex
+	failPart string
+	failPut  bool
+	objects  map[string]int
+	parts    map[string]int
+}
+
+func newFakeS3Server(t *testing.T) (*fakeS3Server, *S3Config) {
+	f := &fakeS3Server{objects: map[string]int{}, parts: map[string]int{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	return f, &S3Config{
+		Endpoint:       server.URL,
+		Region:         "us-east-1",
+		Bucket:         "test",
+		ForcePathStyle: true,
+		AccessKey:      "key",
+		SecretKey:      "secret",
+	}
+}
+
+func (f *fakeS3Server) fail(w http.ResponseWriter) {
+	// a client error, so the SDK doesn't retry it
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = io.WriteString(w, "<Error><Code>InvalidRequest</Code><Message>upload failed</Message></Error>")
+}
+
+func (f *fakeS3Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := req.URL.Query()
+	key := strings.TrimPrefix(req.URL.Path, "/test/")
+	size, _ := io.Copy(io.Discard, req.Body)
+
+	switch {
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		_, _ = fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>test</Bucket><Key>%s</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>", key)
+	case req.Method == http.MethodPut && query.Has("partNumber"):
+		if query.Get("partNumber") == f.failPart {
+			f.failPart = ""
+			f.fail(w)
+			return
+		}
+		f.parts[query.Get("partNumber")] = int(size)
+		w.Header().Set("ETag", `"etag-`+query.Get("partNumber")+`"`)
+	case req.Method == http.MethodGet && query.Has("uploadId"):
+		_, _ = io.WriteString(w, "<ListPartsResult><IsTruncated>false</IsTruncated>")
+		for number := range f.parts {
+			_, _ = fmt.Fprintf(w, `<Part><PartNumber>%s</PartNumber><ETag>"etag-%s"</ETag></Part>`, number, number)
+		}
+		_, _ = io.WriteString(w, "</ListPartsResult>")
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		total := 0
+		for _, partSize := range f.parts {
+			total += partSize
+		}
+		f.objects[key] = total
+		f.parts = map[string]int{}
+		_, _ = fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>test</Bucket><Key>%s</Key></CompleteMultipartUploadResult>", key)
+	case req.Method == http.MethodPut:
+		if f.failPut {
+			f.fail(w)
+			return
+		}
+		f.objects[key] = int(size)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestS3PartSize(t *testing.T) {
+	r := require.New(t)
+
+	s := &S3Config{}
+	r.Equal(int64(defaultPartSize), s.partSize(1024))
+
+	s.PartSize = 1024
+	r.Equal(int64(s3manager.MinUploadPartSize), s.partSize(1024), "part size should have a minimum")
+
+	size := int64(s3manager.MaxUploadParts) * s3manager.MinUploadPartSize * 2
+	r.Less(size/s.partSize(size), int64(s3manager.MaxUploadParts), "part count should be under the limit")
+}
+
+func TestS3ResumableUpload(t *testing.T) {
+	r := require.New(t)
+	resumableRetryDelay = time.Millisecond
+
+	tmp := t.TempDir()
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, make([]byte, 2*s3manager.MinUploadPartSize+1), 0o600), "failed to create backup file")
+
+	f, err := os.Open(src)
+	r.NoError(err)
+	defer f.Close()
+
+	s := &S3Config{Bucket: "backups", PartSize: s3manager.MinUploadPartSize}
+	input := &s3manager.UploadInput{Bucket: aws.String("backups"), Key: aws.String("db/test-20250101000000.sql")}
+	client := &multipartS3Client{failPart: 2}
+
+	err = s.resumableUpload(client, f, src, input)
+	r.Error(err, "upload without retries should fail")
+	r.FileExists(uploadStatePath(src), "state should be kept after a failed upload")
+	r.Equal([]int64{1}, client.uploaded)
+
+	err = s.resumableUpload(client, f, src, input)
+	r.NoError(err, "upload should be resumed")
+	r.Equal(1, client.created, "the previous upload should be reused")
+	r.Equal([]int64{1, 2, 3}, client.uploaded, "only the missing parts should be uploaded")
+	r.Len(client.completed, 3)
+	r.NoFileExists(uploadStatePath(src), "state should be removed after the upload")
+
+	// retries happen on the same call
+	client = &multipartS3Client{failPart: 3}
+	s.UploadRetries = 1
+
+	err = s.resumableUpload(client, f, src, input)
+	r.NoError(err, "upload should be retried")
+	r.Equal([]int64{1, 2, 3}, client.uploaded)
+}
+
+func TestS3ResumableUploadChangedFile(t *testing.T) {
+	r := require.New(t)
+
+	tmp := t.TempDir()
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, make([]byte, 2*s3manager.MinUploadPartSize+1), 0o600), "failed to create backup file")
+
+	r.NoError(writeUploadState(src, &uploadState{
+		Bucket:   "backups",
+		Key:      "db/test-20250101000000.sql",
+		UploadID: "upload-1",
+		Size:     1024,
+		PartSize: s3manager.MinUploadPartSize,
+	}))
+
+	f, err := os.Open(src)
+	r.NoError(err)
+	defer f.Close()
+
+	s := &S3Config{Bucket: "backups", PartSize: s3manager.MinUploadPartSize}
+	input := &s3manager.UploadInput{Bucket: aws.String("backups"), Key: aws.String("db/test-20250101000000.sql")}
+	client := &multipartS3Client{created: 1, parts: map[int64]string{1: "etag-1"}}
+
+	err = s.resumableUpload(client, f, src, input)
+	r.NoError(err, "upload should succeed")
+	r.Equal(2, client.created, "a new upload should be created for a different file")
+	r.Equal([]int64{1, 2, 3}, client.uploaded)
+}
+
+func TestS3AbortStaleUploads(t *testing.T) {
+	r := require.New(t)
+
+	tmp := t.TempDir()
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+	r.NoError(writeUploadState(src, &uploadState{UploadID: "old"}))
+
+	client := &multipartS3Client{
+		uploads: []*s3.MultipartUpload{
+			{Key: aws.String("db/test-20250101000000.sql"), UploadId: aws.String("old"), Initiated: aws.Time(time.Now().Add(-48 * time.Hour))},
+			{Key: aws.String("db/test-20250102000000.sql"), UploadId: aws.String("new"), Initiated: aws.Time(time.Now())},
+		},
+	}
+
+	s := &S3Config{Bucket: "backups", SaveDir: tmp}
+	s.abortStaleUploads(client, "db/")
+	r.Empty(client.aborted, "uploads shouldn't be aborted if disabled")
+
+	s.StaleUploadAge = 24 * time.Hour
+	s.abortStaleUploads(client, "db/")
+	r.Equal([]string{"old"}, client.aborted, "only stale uploads should be aborted")
+	r.NoFileExists(uploadStatePath(src), "state of aborted uploads should be removed")
+	r.NoFileExists(src, "files of aborted uploads should be removed")
+}
+
+func TestS3ResumePendingUploads(t *testing.T) {
+	r := require.New(t)
+	resumableRetryDelay = time.Millisecond
+
+	tmp := t.TempDir()
+	r.NoError(os.Mkdir(path.Join(tmp, "db"), 0o755), "failed to create backup directory")
+
+	src := path.Join(tmp, "db", "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, make([]byte, 2*s3manager.MinUploadPartSize+1), 0o600), "failed to create backup file")
+
+	f, err := os.Open(src)
+	r.NoError(err)
+
+	s := &S3Config{Bucket: "backups", PartSize: s3manager.MinUploadPartSize, SaveDir: tmp, StaleUploadAge: 24 * time.Hour}
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String("backups"),
+		Key:      aws.String("db/test-20250101000000.sql"),
+		Metadata: aws.StringMap(map[string]string{"sha256": "abcd"}),
+	}
+	client := &multipartS3Client{failPart: 2}
+
+	err = s.resumableUpload(client, f, src, input)
+	r.NoError(f.Close())
+	r.Error(err, "upload without retries should fail")
+
+	state, err := readUploadState(src)
+	r.NoError(err)
+	r.Equal(map[string]string{"sha256": "abcd"}, state.Metadata, "the metadata should be saved to create the upload again")
+
+	// the next run continues the upload
+	r.NoError(s.resumePendingUploads(client), "pending upload should be resumed")
+	r.Equal(1, client.created, "the previous upload should be reused")
+	r.Equal([]int64{1, 2, 3}, client.uploaded, "only the missing parts should be uploaded")
+	r.NoFileExists(uploadStatePath(src), "state should be removed after the upload")
+	r.NoFileExists(src, "the file should be removed after the upload")
+
+	// files that can't be uploaded for too long are removed
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+	r.NoError(writeUploadState(src, &uploadState{Bucket: "backups", Key: "db/test-20250101000000.sql", UploadID: "old"}))
+	r.NoError(os.Chtimes(src, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)))
+
+	// the state of files that no longer exist is removed
+	missing := path.Join(tmp, "db", ".test-20250102000000.sql.mirror-0")
+	r.NoError(writeUploadState(missing, &uploadState{Bucket: "backups", Key: "db/test-20250102000000.sql", UploadID: "gone"}))
+
+	client = &multipartS3Client{}
+	r.NoError(s.resumePendingUploads(client))
+	r.ElementsMatch([]string{"old", "gone"}, client.aborted, "the uploads should be aborted")
+	r.NoFileExists(src, "stale files should be removed")
+	r.NoFileExists(uploadStatePath(src), "state of stale files should be removed")
+	r.NoFileExists(uploadStatePath(missing), "state of missing files should be removed")
+	r.Empty(client.uploaded)
+}
+
+func TestS3FailedSinglePartUpload(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	server, store := newFakeS3Server(t)
+	server.failPut = true
+	store.ResumableUpload = true
+	store.SaveDir = tmp
+
+	src := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(src, []byte("test"), 0o600), "failed to create backup file")
+
+	err := store.Store(src, "db", path.Base(src))
+	r.ErrorContains(err, "failed to upload file")
+	r.NoFileExists(src, "files without an upload state cannot be resumed and should be removed")
+
+	// a failed multipart upload keeps the file and its state
+	src = path.Join(tmp, "test-20250102000000.sql")
+	r.NoError(os.WriteFile(src, make([]byte, 2*s3manager.MinUploadPartSize+1), 0o600), "failed to create backup file")
+	store.PartSize = s3manager.MinUploadPartSize
+	server.failPart = "2"
+
+	err = store.Store(src, "db", path.Base(src))
+	r.ErrorContains(err, "failed to upload file")
+	r.FileExists(src, "the file should be kept to resume the upload")
+	r.FileExists(uploadStatePath(src), "the upload state should be kept")
+}
@@ -0,0 +1,529 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3ApplyEncryption(t *testing.T) {
+	r := require.New(t)
+
+	input := &s3manager.UploadInput{}
+	err := (&S3Config{}).applyEncryption(input)
+	r.NoError(err)
+	r.Nil(input.ServerSideEncryption, "encryption should be disabled by default")
+
+	input = &s3manager.UploadInput{}
+	err = (&S3Config{SSE: "AES256"}).applyEncryption(input)
+	r.NoError(err)
+	r.Equal("AES256", aws.StringValue(input.ServerSideEncryption))
+
+	input = &s3manager.UploadInput{}
+	store := &S3Config{SSEKMSKeyID: "alias/backups", SSEKMSContext: map[string]string{"app": "backup"}}
+	err = store.applyEncryption(input)
+	r.NoError(err)
+	r.Equal("aws:kms", aws.StringValue(input.ServerSideEncryption), "SSE-KMS should be used when a key is set")
+	r.Equal("alias/backups", aws.StringValue(input.SSEKMSKeyId))
+	context, err := base64.StdEncoding.DecodeString(aws.StringValue(input.SSEKMSEncryptionContext))
+	r.NoError(err)
+	r.JSONEq(`{"app":"backup"}`, string(context))
+
+	err = (&S3Config{SSE: "invalid"}).applyEncryption(&s3manager.UploadInput{})
+	r.Error(err, "unknown encryption types should be rejected")
+}
+
+func TestS3CustomerKey(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+	key := bytes.Repeat([]byte{0xab}, 32)
+
+	raw := path.Join(tmp, "raw.key")
+	r.NoError(os.WriteFile(raw, key, 0o600))
+	encoded := path.Join(tmp, "encoded.key")
+	r.NoError(os.WriteFile(encoded, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600))
+	short := path.Join(tmp, "short.key")
+	r.NoError(os.WriteFile(short, []byte("short"), 0o600))
+
+	for _, file := range []string{raw, encoded} {
+		input := &s3manager.UploadInput{}
+		err := (&S3Config{SSECustomerKey: file}).applyEncryption(input)
+		r.NoError(err)
+		r.Equal("AES256", aws.StringValue(input.SSECustomerAlgorithm))
+		r.Equal(string(key), aws.StringValue(input.SSECustomerKey))
+	}
+
+	err := (&S3Config{SSECustomerKey: short}).applyEncryption(&s3manager.UploadInput{})
+	r.Error(err, "keys with the wrong size should be rejected")
+
+	err = (&S3Config{SSECustomerKey: raw, SSE: "AES256"}).applyEncryption(&s3manager.UploadInput{})
+	r.Error(err, "SSE-C cannot be combined with other encryption types")
+}
+
+type restoreS3Client struct {
+	s3iface.S3API
+	storageClass string
+	restore      *string
+	heads        int
+	restores     int
+	readyAfter   int
+}
+
+func (c *restoreS3Client) HeadObject(_ *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	c.heads++
+	if c.restores > 0 && c.heads > c.readyAfter {
+		c.restore = aws.String(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	}
+
+	return &s3.HeadObjectOutput{StorageClass: aws.String(c.storageClass), Restore: c.restore}, nil
+}
+
+func (c *restoreS3Client) RestoreObject(_ *s3.RestoreObjectInput) (*s3.RestoreObjectOutput, error) {
+	c.restores++
+	c.restore = aws.String(`ongoing-request="true"`)
+
+	return &s3.RestoreObjectOutput{}, nil
+}
+
+func TestS3WaitForRestore(t *testing.T) {
+	r := require.New(t)
+	head := &s3.HeadObjectInput{Bucket: aws.String("test"), Key: aws.String("test-20250101000000.sql")}
+	store := &S3Config{RestorePollInterval: time.Millisecond, RestoreTimeout: time.Second}
+
+	client := &restoreS3Client{storageClass: s3.StorageClassStandard}
+	_, err := store.waitForRestore(client, head)
+	r.NoError(err)
+	r.Equal(0, client.restores, "objects on standard tiers should be downloaded directly")
+
+	client = &restoreS3Client{storageClass: s3.StorageClassDeepArchive, readyAfter: 3}
+	_, err = store.waitForRestore(client, head)
+	r.NoError(err)
+	r.Equal(1, client.restores, "archived objects should be restored")
+	r.Equal(4, client.heads, "restore status should be polled until it finishes")
+
+	client = &restoreS3Client{storageClass: s3.StorageClassGlacier, restore: aws.String(`ongoing-request="true"`), readyAfter: 100}
+	store.RestoreTimeout = 10 * time.Millisecond
+	_, err = store.waitForRestore(client, head)
+	r.Error(err, "restore should time out")
+	r.Equal(0, client.restores, "restores in progress should not be requested again")
+}
+
+func TestS3ApplyObjectLock(t *testing.T) {
+	r := require.New(t)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	input := &s3manager.UploadInput{}
+	r.NoError((&S3Config{}).applyObjectLock(input, now))
+	r.Nil(input.ObjectLockMode, "object lock should be disabled by default")
+
+	input = &s3manager.UploadInput{}
+	r.NoError((&S3Config{ObjectLockMode: "governance", ObjectLockDays: 30}).applyObjectLock(input, now))
+	r.Equal(s3.ObjectLockModeGovernance, aws.StringValue(input.ObjectLockMode))
+	r.Equal(now.AddDate(0, 0, 30), aws.TimeValue(input.ObjectLockRetainUntilDate))
+
+	r.Error((&S3Config{ObjectLockMode: "COMPLIANCE"}).applyObjectLock(&s3manager.UploadInput{}, now),
+		"a retention period is required")
+	r.Error((&S3Config{ObjectLockMode: "invalid", ObjectLockDays: 1}).applyObjectLock(&s3manager.UploadInput{}, now),
+		"unknown modes should be rejected")
+}
+
+type lockedS3Client struct {
+	s3iface.S3API
+	keys    []string
+	locked  map[string]*s3.HeadObjectOutput
+	deleted []string
+}
+
+func (c *lockedS3Client) ListObjectsV2Pages(_ *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	var contents []*s3.Object
+	for _, key := range c.keys {
+		contents = append(contents, &s3.Object{Key: aws.String(key)})
+	}
+
+	fn(&s3.ListObjectsV2Output{Contents: contents}, true)
+
+	return nil
+}
+
+func (c *lockedS3Client) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if out, ok := c.locked[aws.StringValue(input.Key)]; ok {
+		return out, nil
+	}
+
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (c *lockedS3Client) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	out := &s3.DeleteObjectsOutput{}
+	for _, obj := range input.Delete.Objects {
+		c.deleted = append(c.deleted, aws.StringValue(obj.Key))
+		out.Deleted = append(out.Deleted, &s3.DeletedObject{Key: obj.Key})
+	}
+
+	return out, nil
+}
+
+func TestS3RemoveOlderBackupsSkipsLocked(t *testing.T) {
+	r := require.New(t)
+
+	client := &lockedS3Client{
+		keys: []string{
+			"db/test-20250101000000.sql",
+			"db/test-20250102000000.sql",
+			"db/test-20250103000000.sql",
+			"db/test-20250104000000.sql",
+		},
+		locked: map[string]*s3.HeadObjectOutput{
+			"db/test-20250101000000.sql": {ObjectLockRetainUntilDate: aws.Time(time.Now().Add(time.Hour))},
+			"db/test-20250102000000.sql": {ObjectLockRetainUntilDate: aws.Time(time.Now().Add(-time.Hour))},
+			"db/test-20250103000000.sql": {ObjectLockLegalHoldStatus: aws.String(s3.ObjectLockLegalHoldStatusOn)},
+		},
+	}
+
+	store := &S3Config{Bucket: "test"}
+	err := store.removeOlderBackups(client, "db", "test", RetentionPolicy{Keep: 1})
+	r.NoError(err, "locked objects should not fail the removal")
+	r.Equal([]string{"db/test-20250102000000.sql"}, client.deleted, "only unlocked objects should be deleted")
+
+	client.deleted = nil
+	client.locked = nil
+	client.keys = client.keys[:2]
+	r.NoError(store.removeOlderBackups(client, "db", "test", RetentionPolicy{Keep: 2}))
+	r.Empty(client.deleted, "nothing should be deleted when under the limit")
+}
+
+func TestS3SessionCredentials(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	store := &S3Config{Region: "us-east-1", AccessKey: "static-key", SecretKey: "static-secret"}
+	sess, err := store.newSession()
+	r.NoError(err)
+	creds, err := sess.Config.Credentials.Get()
+	r.NoError(err)
+	r.Equal("static-key", creds.AccessKeyID, "static keys should be used")
+
+	shared := path.Join(tmp, "credentials")
+	r.NoError(os.WriteFile(shared, []byte("[backups]\naws_access_key_id = profile-key\naws_secret_access_key = profile-secret\n"), 0o600))
+
+	store = &S3Config{Region: "us-east-1", Profile: "backups", SharedCredentialsFile: shared}
+	sess, err = store.newSession()
+	r.NoError(err)
+	creds, err = sess.Config.Credentials.Get()
+	r.NoError(err)
+	r.Equal("profile-key", creds.AccessKeyID, "profile from the shared credentials file should be used")
+}
+
+func TestS3SessionAssumeRole(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		form = req.PostForm
+		action := req.PostForm.Get("Action")
+		_, _ = fmt.Fprintf(w, `<%[1]sResponse><%[1]sResult><Credentials>
+<AccessKeyId>role-key</AccessKeyId><SecretAccessKey>role-secret</SecretAccessKey>
+<SessionToken>role-token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration>
+</Credentials></%[1]sResult></%[1]sResponse>`, action)
+	}))
+	defer server.Close()
+
+	store := &S3Config{
+		Region:          "us-east-1",
+		AccessKey:       "static-key",
+		SecretKey:       "static-secret",
+		RoleARN:         "arn:aws:iam::123456789012:role/backups",
+		RoleExternalID:  "external",
+		RoleSessionName: "backups",
+		STSEndpoint:     server.URL,
+	}
+	sess, err := store.newSession()
+	r.NoError(err)
+	creds, err := sess.Config.Credentials.Get()
+	r.NoError(err)
+	r.Equal("role-key", creds.AccessKeyID, "assumed role credentials should be used")
+	r.Equal("AssumeRole", form.Get("Action"))
+	r.Equal("external", form.Get("ExternalId"))
+	r.Equal("backups", form.Get("RoleSessionName"))
+
+	token := path.Join(tmp, "token")
+	r.NoError(os.WriteFile(token, []byte("web-token"), 0o600))
+
+	store = &S3Config{
+		Region:               "us-east-1",
+		RoleARN:              "arn:aws:iam::123456789012:role/backups",
+		WebIdentityTokenFile: token,
+		STSEndpoint:          server.URL,
+	}
+	sess, err = store.newSession()
+	r.NoError(err)
+	creds, err = sess.Config.Credentials.Get()
+	r.NoError(err)
+	r.Equal("role-key", creds.AccessKeyID, "web identity credentials should be used")
+	r.Equal("AssumeRoleWithWebIdentity", form.Get("Action"))
+	r.Equal("web-token", form.Get("WebIdentityToken"))
+}
+
+// writeClientCert creates a self-signed client certificate and returns its pool, certificate and key files
+func writeClientCert(t *testing.T, dir string) (*x509.CertPool, string, string) {
+	r := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "backups"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	r.NoError(err)
+
+	cert, err := x509.ParseCertificate(der)
+	r.NoError(err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	r.NoError(err)
+
+	certFile := path.Join(dir, "client.pem")
+	keyFile := path.Join(dir, "client-key.pem")
+	r.NoError(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	r.NoError(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return pool, certFile, keyFile
+}
+
+func TestS3HTTPClient(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	pool, certFile, keyFile := writeClientCert(t, tmp)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+
+	client, err := (&S3Config{}).httpClient()
+	r.NoError(err)
+	r.Nil(client, "the default client should be used without options")
+
+	ca := path.Join(tmp, "ca.pem")
+	r.NoError(os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	sess, err := (&S3Config{Region: "us-east-1", CAFile: ca}).newSession()
+	r.NoError(err)
+	_, err = sess.Config.HTTPClient.Get(server.URL)
+	r.Error(err, "the server should ask for a client certificate")
+
+	store := &S3Config{Region: "us-east-1", CAFile: ca, ClientCertFile: certFile, ClientKeyFile: keyFile}
+	sess, err = store.newSession()
+	r.NoError(err)
+	res, err := sess.Config.HTTPClient.Get(server.URL)
+	r.NoError(err, "the CA bundle and client certificate should be used")
+	r.NoError(res.Body.Close())
+
+	store = &S3Config{Region: "us-east-1", InsecureSkipVerify: true, ClientCertFile: certFile, ClientKeyFile: keyFile}
+	sess, err = store.newSession()
+	r.NoError(err)
+	res, err = sess.Config.HTTPClient.Get(server.URL)
+	r.NoError(err, "verification should be skipped")
+	r.NoError(res.Body.Close())
+
+	client, err = (&S3Config{ProxyURL: "http://proxy.local:3128"}).httpClient()
+	r.NoError(err)
+	proxy, err := client.Transport.(*http.Transport).Proxy(httptest.NewRequest(http.MethodGet, "https://s3.amazonaws.com", nil))
+	r.NoError(err)
+	r.Equal("http://proxy.local:3128", proxy.String())
+
+	_, err = (&S3Config{Region: "us-east-1", ClientCertFile: certFile}).newSession()
+	r.ErrorContains(err, "client key", "the client certificate needs a key")
+
+	_, err = (&S3Config{Region: "us-east-1", CAFile: path.Join(tmp, "missing.pem")}).newSession()
+	r.Error(err, "missing CA file should fail")
+}
+
+func TestS3VerifyChecksum(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	file := path.Join(tmp, "test-20250101000000.sql")
+	r.NoError(os.WriteFile(file, []byte("test"), 0o600))
+
+	checksum, err := fileChecksum(file)
+	r.NoError(err)
+	r.Equal("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", checksum)
+
+	r.NoError(verifyChecksum(file, map[string]*string{"Sha256": aws.String(checksum)}))
+	r.NoError(verifyChecksum(file, map[string]*string{"sha256": aws.String(checksum)}), "metadata keys are case insensitive")
+	r.NoError(verifyChecksum(file, nil), "backups without checksum should be accepted")
+
+	err = verifyChecksum(file, map[string]*string{"Sha256": aws.String("invalid")})
+	r.ErrorContains(err, "checksum mismatch")
+}
+
+func TestS3ObjectMetadataTags(t *testing.T) {
+	r := require.New(t)
+
+	store := &S3Config{SSE: "aws:kms", Tags: []string{"service", "database", "schema", "env=prod"}}
+	details := map[string]string{"service": "postgres", "database": "app", "schema": "", "sha256": "abcd"}
+
+	metadata := store.objectMetadata(details, 1024)
+	r.Equal(map[string]string{
+		"service":    "postgres",
+		"database":   "app",
+		"encryption": "aws:kms",
+		"size":       "1024",
+		"sha256":     "abcd",
+	}, metadata, "empty values should be skipped")
+
+	tags, err := url.ParseQuery(store.objectTags(metadata))
+	r.NoError(err)
+	r.Equal(url.Values{"service": {"postgres"}, "database": {"app"}, "env": {"prod"}}, tags)
+
+	r.Empty((&S3Config{}).objectTags(metadata), "no tags should be set by default")
+}
+
+func TestS3Presign(t *testing.T) {
+	r := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/test/db/test-20250101000000.sql":
+			w.WriteHeader(http.StatusOK)
+		case "/test/db/test-20250102000000.sql":
+			w.Header().Set("X-Amz-Storage-Class", s3.StorageClassGlacier)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store := &S3Config{
+		Endpoint:       server.URL,
+		Region:         "us-east-1",
+		Bucket:         "test",
+		ForcePathStyle: true,
+		AccessKey:      "key",
+		SecretKey:      "secret",
+	}
+
+	signed, err := store.Presign("db/test-20250101000000.sql", time.Hour)
+	r.NoError(err, "failed to presign object")
+
+	parsed, err := url.Parse(signed)
+	r.NoError(err)
+	r.Equal("/test/db/test-20250101000000.sql", parsed.Path)
+	r.Equal("3600", parsed.Query().Get("X-Amz-Expires"))
+	r.NotEmpty(parsed.Query().Get("X-Amz-Signature"))
+
+	_, err = store.Presign("db/test-20250102000000.sql", time.Hour)
+	r.Error(err, "archived objects cannot be presigned")
+
+	_, err = store.Presign("db/missing.sql", time.Hour)
+	r.Error(err, "missing objects cannot be presigned")
+}
+
+type versionsS3Client struct {
+	s3iface.S3API
+	prefix string
+}
+
+func (c *versionsS3Client) ListObjectVersionsPages(input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+	c.prefix = aws.StringValue(input.Prefix)
+	day := func(d int) *time.Time { return aws.Time(time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)) }
+
+	fn(&s3.ListObjectVersionsOutput{
+		Versions: []*s3.ObjectVersion{
+			{Key: aws.String("backups/test-20250101000000.sql"), VersionId: aws.String("v1"), LastModified: day(1), Size: aws.Int64(10)},
+			{Key: aws.String("backups/test-20250101000000.sql"), VersionId: aws.String("v2"), LastModified: day(2), Size: aws.Int64(20)},
+			{Key: aws.String("backups/test-20250102000000.sql"), VersionId: aws.String("v3"), LastModified: day(2), IsLatest: aws.Bool(true)},
+			{Key: aws.String("backups/other.txt"), VersionId: aws.String("v4"), LastModified: day(2)},
+		},
+	}, false)
+	fn(&s3.ListObjectVersionsOutput{
+		DeleteMarkers: []*s3.DeleteMarkerEntry{
+			{Key: aws.String("backups/test-20250101000000.sql"), VersionId: aws.String("v5"), LastModified: day(3), IsLatest: aws.Bool(true)},
+		},
+	}, true)
+
+	return nil
+}
+
+func TestS3ListVersions(t *testing.T) {
+	r := require.New(t)
+	store := &S3Config{Bucket: "test", Prefix: "backups"}
+
+	client := &versionsS3Client{}
+	versions, err := store.listVersions(client, "backups/test-20250101000000.sql", "")
+	r.NoError(err)
+	r.Equal("backups/test-20250101000000.sql", client.prefix)
+	r.Len(versions, 3, "every version of the key should be listed")
+	r.Equal("v5", versions[0].VersionID, "newest version should be first")
+	r.True(versions[0].DeleteMarker)
+	r.Equal("v2", versions[1].VersionID)
+	r.Equal(int64(20), versions[1].Size)
+	r.Equal("v1", versions[2].VersionID)
+
+	versions, err = store.listVersions(client, "", "test")
+	r.NoError(err)
+	r.Equal("backups/", client.prefix)
+	r.Len(versions, 4, "unrelated files should be skipped")
+	r.Equal("backups/test-20250102000000.sql", versions[0].Key)
+}
+
+func TestS3FindLatestBackupVersion(t *testing.T) {
+	r := require.New(t)
+
+	store := &S3Config{Bucket: "test", VersionID: "v1"}
+	_, err := store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "restore file", "a version cannot be applied to the latest backup")
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig has the config options for the SFTP service
+type SFTPConfig struct {
+	Host                  string
+	Port                  string
+	User                  string
+	Password              string
+	PrivateKey            string
+	PrivateKeyPassphrase  string
+	KnownHostsFile        string
+	InsecureIgnoreHostKey bool
+	RemoteDir             string
+	KeepAfterUpload       bool
+	SaveDir               string
+	retrievedFile         string
+}
+
+type sftpClient struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+func (c *sftpClient) Close() {
+	if err := c.Client.Close(); err != nil {
+		slog.Warn("Cannot close SFTP session", "error", err)
+	}
+
+	if err := c.conn.Close(); err != nil {
+		slog.Warn("Cannot close SSH connection", "error", err)
+	}
+}
+
+func (s *SFTPConfig) newClientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+
+	if s.PrivateKey != "" {
+		key, err := os.ReadFile(s.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read private key %s, %v", s.PrivateKey, err)
+		}
+
+		var signer ssh.Signer
+		if s.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(s.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse private key %s, %v", s.PrivateKey, err)
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if s.Password != "" {
+		auth = append(auth, ssh.Password(s.Password))
+	}
+
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no SFTP authentication method configured, set a password or a private key")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if s.InsecureIgnoreHostKey {
+		slog.Warn("Host key verification is disabled for the SFTP store")
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHostsFile := s.KnownHostsFile
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("cannot find the home directory, %v", err)
+			}
+			knownHostsFile = path.Join(home, ".ssh", "known_hosts")
+		}
+
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load known hosts file %s, %v", knownHostsFile, err)
+		}
+		hostKeyCallback = callback
+	}
+
+	return &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+func (s *SFTPConfig) newClient() (*sftpClient, error) {
+	config, err := s.newClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	port := s.Port
+	if port == "" {
+		port = "22"
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(s.Host, port), config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to SSH server %s, %v", s.Host, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("cannot start SFTP session, %v", err)
+	}
+
+	return &sftpClient{Client: client, conn: conn}, nil
+}
+
+// Store saves a file to a remote SFTP server
+func (s *SFTPConfig) Store(filepath, prefix, filename string) error {
+	client, err := s.newClient()
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer func(f *os.File) {
+		closeErr := f.Close()
+		if closeErr != nil {
+			slog.Error("Cannot close file", "path", filepath, "error", closeErr)
+		}
+	}(f)
+
+	if !s.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err = os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	dest := path.Clean(path.Join(s.RemoteDir, prefix, filename))
+
+	if err = client.MkdirAll(path.Dir(dest)); err != nil {
+		return fmt.Errorf("cannot create remote directory %s, %v", path.Dir(dest), err)
+	}
+
+	destFile, err := client.Create(dest)
+	if err != nil {
+		return fmt.Errorf("cannot create remote file %s, %v", dest, err)
+	}
+
+	if _, err = destFile.ReadFrom(f); err != nil {
+		_ = destFile.Close()
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	if err = destFile.Close(); err != nil {
+		return fmt.Errorf("cannot close remote file %s, %v", dest, err)
+	}
+
+	slog.Debug("File uploaded", "location", dest)
+
+	return nil
+}
+
+func (s *SFTPConfig) getFileListing(basedir, namePrefix string, client *sftpClient) ([]string, error) {
+	fullBasedir := path.Clean(path.Join(s.RemoteDir, basedir))
+	files, err := client.ReadDir(fullBasedir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list contents of remote directory %s, %v", fullBasedir, err)
+	}
+	re := generatePattern(namePrefix)
+
+	var filenames []string
+	for _, f := range files {
+		if !f.IsDir() {
+			// ignore files not created by this program
+			if re.MatchString(f.Name()) {
+				filenames = append(filenames, path.Join(fullBasedir, f.Name()))
+			}
+		}
+	}
+
+	return filenames, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the SFTP server and deletes the old ones
+func (s *SFTPConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	client, err := s.newClient()
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	files, err := s.getFileListing(basedir, namePrefix, client)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := 0
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			if err = client.Remove(file); err != nil {
+				slog.Error("Failed to remove remote file", "name", file, "error", err)
+			} else {
+				deleted++
+			}
+		}
+
+		slog.Debug("Deleted objects from SFTP server", "count", deleted, "path", path.Join(s.RemoteDir, basedir))
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the SFTP server
+func (s *SFTPConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	client, err := s.newClient()
+	if err != nil {
+		return "", err
+	}
+
+	defer client.Close()
+
+	files, err := s.getFileListing(basedir, namePrefix, client)
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on sftp://%s/%s", s.Host, s.RemoteDir)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads a remote file to the local filesystem
+func (s *SFTPConfig) Retrieve(remotePath string) (string, error) {
+	client, err := s.newClient()
+	if err != nil {
+		return "", err
+	}
+
+	defer client.Close()
+
+	srcFile, err := client.Open(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("cannot open remote file %s, %v", remotePath, err)
+	}
+
+	defer srcFile.Close()
+
+	filepath := path.Join(s.SaveDir, path.Base(remotePath))
+	f, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer f.Close()
+
+	if _, err = io.Copy(f, srcFile); err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download remote file, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	s.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (s *SFTPConfig) Close() {
+	if s.retrievedFile != "" {
+		if err := os.Remove(s.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", s.retrievedFile, "error", err)
+		}
+
+		s.retrievedFile = ""
+	}
+}
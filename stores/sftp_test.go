@@ -0,0 +1,182 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func startSFTPServer(t *testing.T, password string) (string, ssh.PublicKey) {
+	r := require.New(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	r.NoError(err, "failed to generate host key")
+	signer, err := ssh.NewSignerFromKey(key)
+	r.NoError(err, "failed to create host signer")
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected")
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err, "failed to listen")
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				_ = req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		_ = server.Serve()
+		_ = server.Close()
+	}
+}
+
+func TestSFTPStoreRetrieve(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	addr, hostKey := startSFTPServer(t, "secret")
+	host, port, err := net.SplitHostPort(addr)
+	r.NoError(err, "failed to parse server address")
+
+	knownHosts := path.Join(tmp, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+	err = os.WriteFile(knownHosts, []byte(line+"\n"), 0o600)
+	r.NoError(err, "failed to create known hosts file")
+
+	remoteDir := path.Join(tmp, "remote")
+	saveDir := path.Join(tmp, "local")
+	err = os.Mkdir(saveDir, 0o755)
+	r.NoError(err, "failed to create local directory")
+
+	store := &SFTPConfig{
+		Host:           host,
+		Port:           port,
+		User:           "test",
+		Password:       "secret",
+		KnownHostsFile: knownHosts,
+		RemoteDir:      remoteDir,
+		SaveDir:        saveDir,
+	}
+
+	names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "test-20250103000000.sql"}
+	for _, name := range names {
+		src := path.Join(saveDir, name)
+		err = os.WriteFile(src, []byte(name), 0o600)
+		r.NoError(err, "failed to create backup file")
+
+		err = store.Store(src, "db", name)
+		r.NoError(err, "failed to store file")
+		r.NoFileExists(src, "source file should be removed after upload")
+	}
+
+	err = store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2})
+	r.NoError(err, "failed to remove older backups")
+	r.NoFileExists(path.Join(remoteDir, "db", names[0]))
+
+	latest, err := store.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal(path.Join(remoteDir, "db", names[2]), latest)
+
+	local, err := store.Retrieve(latest)
+	r.NoError(err, "failed to retrieve backup")
+	actual, err := os.ReadFile(local)
+	r.NoError(err, "failed to read retrieved file")
+	r.Equal(names[2], string(actual))
+
+	store.Close()
+	r.NoFileExists(local, "retrieved file should be removed on close")
+}
+
+func TestSFTPUnknownHost(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	addr, _ := startSFTPServer(t, "secret")
+	host, port, err := net.SplitHostPort(addr)
+	r.NoError(err, "failed to parse server address")
+
+	knownHosts := path.Join(tmp, "known_hosts")
+	err = os.WriteFile(knownHosts, nil, 0o600)
+	r.NoError(err, "failed to create known hosts file")
+
+	store := &SFTPConfig{
+		Host:           host,
+		Port:           port,
+		User:           "test",
+		Password:       "secret",
+		KnownHostsFile: knownHosts,
+		RemoteDir:      tmp,
+	}
+
+	_, err = store.FindLatestBackup("", "test")
+	r.Error(err, "connection to an unknown host should fail")
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ncw/swift/v2"
+)
+
+// swiftMaxObjectSize is the largest object that Swift accepts on a single upload
+const swiftMaxObjectSize = 5 * 1024 * 1024 * 1024
+
+// swiftDefaultSegmentSize is the segment size of the large objects if none is configured
+const swiftDefaultSegmentSize = 10 * 1024 * 1024
+
+// swiftMaxSegments is the default limit of segments on a static large object manifest (max_manifest_segments)
+const swiftMaxSegments = 1000
+
+// SwiftConfig has the config options for the OpenStack Swift service
+type SwiftConfig struct {
+	AuthURL                 string
+	AuthVersion             int
+	User                    string
+	APIKey                  string
+	Domain                  string
+	Tenant                  string
+	TenantDomain            string
+	ApplicationCredentialID string
+	ApplicationSecret       string
+	Region                  string
+	Container               string
+	SegmentContainer        string
+	Prefix                  string
+	SegmentSize             int64
+	LargeObjectThreshold    int64
+	KeepAfterUpload         bool
+	SaveDir                 string
+	retrievedFile           string
+}
+
+func (s *SwiftConfig) newConnection(ctx context.Context) (*swift.Connection, error) {
+	conn := &swift.Connection{
+		AuthUrl:                     s.AuthURL,
+		AuthVersion:                 s.AuthVersion,
+		UserName:                    s.User,
+		ApiKey:                      s.APIKey,
+		Domain:                      s.Domain,
+		Tenant:                      s.Tenant,
+		TenantDomain:                s.TenantDomain,
+		ApplicationCredentialId:     s.ApplicationCredentialID,
+		ApplicationCredentialSecret: s.ApplicationSecret,
+		Region:                      s.Region,
+	}
+
+	if err := conn.Authenticate(ctx); err != nil {
+		return nil, fmt.Errorf("cannot authenticate to Swift, %v", err)
+	}
+
+	return conn, nil
+}
+
+func (s *SwiftConfig) segmentContainer() string {
+	if s.SegmentContainer != "" {
+		return s.SegmentContainer
+	}
+
+	return s.Container + "_segments"
+}
+
+func (s *SwiftConfig) largeObjectThreshold() int64 {
+	if s.LargeObjectThreshold > 0 && s.LargeObjectThreshold < swiftMaxObjectSize {
+		return s.LargeObjectThreshold
+	}
+
+	return swiftMaxObjectSize
+}
+
+func (s *SwiftConfig) segmentSize(size int64) int64 {
+	segmentSize := s.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = swiftDefaultSegmentSize
+	}
+
+	// the manifest is rejected if the object has too many segments
+	if size/segmentSize >= swiftMaxSegments {
+		segmentSize = size/swiftMaxSegments + 1
+	}
+
+	return segmentSize
+}
+
+// Store saves a file to a remote Swift container
+func (s *SwiftConfig) Store(filepath, prefix, filename string) error {
+	ctx := context.Background()
+
+	conn, err := s.newConnection(ctx)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer func(f *os.File) {
+		closeErr := f.Close()
+		if closeErr != nil {
+			slog.Error("Cannot close file", "path", filepath, "error", closeErr)
+		}
+	}(f)
+
+	if !s.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err = os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat file %q, %v", filepath, err)
+	}
+
+	key := path.Clean(path.Join(s.Prefix, prefix, filename))
+
+	if info.Size() <= s.largeObjectThreshold() {
+		if _, err = conn.ObjectPut(ctx, s.Container, key, f, true, "", "", nil); err != nil {
+			return fmt.Errorf("failed to upload file, %v", err)
+		}
+
+		slog.Debug("File uploaded", "container", s.Container, "key", key)
+
+		return nil
+	}
+
+	// files that are too big are uploaded as a static large object
+	if err = s.uploadLargeObject(ctx, conn, f, key, info.Size()); err != nil {
+		return err
+	}
+
+	slog.Debug("File uploaded as large object", "container", s.Container, "key", key, "size", info.Size())
+
+	return nil
+}
+
+// uploadLargeObject uploads a static large object. The segments are written under a prefix of
+// their own, so they can be removed if the upload fails before the manifest is written.
+func (s *SwiftConfig) uploadLargeObject(ctx context.Context, conn *swift.Connection, r io.Reader, key string, size int64) error {
+	if err := conn.ContainerCreate(ctx, s.segmentContainer(), nil); err != nil {
+		return fmt.Errorf("cannot create segment container %s, %v", s.segmentContainer(), err)
+	}
+
+	prefix := fmt.Sprintf("%s/%d", key, time.Now().UnixNano())
+
+	w, err := conn.StaticLargeObjectCreate(ctx, &swift.LargeObjectOpts{
+		Container:        s.Container,
+		ObjectName:       key,
+		ChunkSize:        s.segmentSize(size),
+		SegmentContainer: s.segmentContainer(),
+		SegmentPrefix:    prefix,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create large object, %v", err)
+	}
+
+	// closing the writer would publish the manifest of a truncated backup
+	if _, err = io.Copy(w, r); err != nil {
+		s.removeSegments(ctx, conn, prefix)
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	if err = w.Close(); err != nil {
+		s.removeSegments(ctx, conn, prefix)
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	return nil
+}
+
+// removeSegments deletes the segments of a failed large object upload
+func (s *SwiftConfig) removeSegments(ctx context.Context, conn *swift.Connection, prefix string) {
+	names, err := conn.ObjectNamesAll(ctx, s.segmentContainer(), &swift.ObjectsOpts{Prefix: prefix + "/"})
+	if err != nil {
+		slog.Warn("Cannot list segments of failed upload", "container", s.segmentContainer(), "prefix", prefix, "error", err)
+		return
+	}
+
+	for _, name := range names {
+		if err = conn.ObjectDelete(ctx, s.segmentContainer(), name); err != nil && !errors.Is(err, swift.ObjectNotFound) {
+			slog.Warn("Cannot remove segment of failed upload", "container", s.segmentContainer(), "name", name, "error", err)
+		}
+	}
+}
+
+func (s *SwiftConfig) getFileListing(ctx context.Context, basedir, namePrefix string, conn *swift.Connection) ([]string, error) {
+	var files []string
+	re := generatePattern(namePrefix)
+
+	// make sure that the prefix ends with "/"
+	prefix := path.Clean(path.Join(s.Prefix, basedir)) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	names, err := conn.ObjectNamesAll(ctx, s.Container, &swift.ObjectsOpts{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		// ignore files not created by this program
+		if re.MatchString(path.Base(name)) {
+			files = append(files, name)
+		}
+	}
+
+	return files, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the Swift container and deletes the old ones
+func (s *SwiftConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	ctx := context.Background()
+
+	conn, err := s.newConnection(ctx)
+	if err != nil {
+		return err
+	}
+
+	files, err := s.getFileListing(ctx, basedir, namePrefix, conn)
+	if err != nil {
+		return fmt.Errorf("couldn't list Swift objects, %v", err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := 0
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			slog.Debug("Marked to delete", "container", s.Container, "file", file)
+			// also removes the segments of large objects
+			if err = conn.LargeObjectDelete(ctx, s.Container, file); err != nil {
+				slog.Error("Failed to remove object", "name", file, "error", err)
+			} else {
+				deleted++
+			}
+		}
+
+		slog.Debug("Deleted objects from Swift", "count", deleted)
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the Swift container
+func (s *SwiftConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	ctx := context.Background()
+
+	conn, err := s.newConnection(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	files, err := s.getFileListing(ctx, basedir, namePrefix, conn)
+	if err != nil {
+		return "", fmt.Errorf("couldn't list Swift objects, %v", err)
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on swift://%s/%s", s.Container, s.Prefix)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads a Swift object to the local filesystem
+func (s *SwiftConfig) Retrieve(key string) (string, error) {
+	ctx := context.Background()
+
+	conn, err := s.newConnection(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	filepath := path.Join(s.SaveDir, path.Base(key))
+	f, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer f.Close()
+
+	if _, err = conn.ObjectGet(ctx, s.Container, key, f, false, nil); err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download Swift object, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	s.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (s *SwiftConfig) Close() {
+	if s.retrievedFile != "" {
+		if err := os.Remove(s.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", s.retrievedFile, "error", err)
+		}
+
+		s.retrievedFile = ""
+	}
+}
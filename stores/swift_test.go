@@ -0,0 +1,143 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ncw/swift/v2"
+	"github.com/ncw/swift/v2/swifttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwiftStoreRetrieve(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	server, err := swifttest.NewSwiftServer("localhost")
+	r.NoError(err, "failed to start swift server")
+	defer server.Close()
+
+	store := &SwiftConfig{
+		AuthURL:   server.AuthURL,
+		User:      swifttest.TEST_ACCOUNT,
+		APIKey:    swifttest.TEST_ACCOUNT,
+		Container: "test",
+		Prefix:    "backups",
+		SaveDir:   tmp,
+		// force large object uploads for the bigger files
+		LargeObjectThreshold: 1024,
+		SegmentSize:          512,
+	}
+
+	ctx := context.Background()
+	conn, err := store.newConnection(ctx)
+	r.NoError(err, "failed to connect to swift server")
+
+	// the container isn't created by the store
+	_, err = store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "couldn't list Swift objects", "a missing container should be reported")
+	r.Error(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2}), "a missing container should be reported")
+
+	r.NoError(conn.ContainerCreate(ctx, "test", nil), "failed to create container")
+
+	names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "test-20250103000000.sql"}
+	contents := map[string][]byte{
+		names[0]: bytes.Repeat([]byte("a"), 2000),
+		names[1]: []byte(names[1]),
+		names[2]: bytes.Repeat([]byte("c"), 1500),
+	}
+
+	for _, name := range names {
+		src := path.Join(tmp, name)
+		err = os.WriteFile(src, contents[name], 0o600)
+		r.NoError(err, "failed to create backup file")
+
+		err = store.Store(src, "db", name)
+		r.NoError(err, "failed to store file")
+	}
+
+	// objects that share the key prefix but weren't created for this backup
+	unrelated := []string{"backups/db/notes.txt", "backups/db/other-20240101000000.sql", "backups/db2/test-20240101000000.sql"}
+	for _, key := range unrelated {
+		r.NoError(conn.ObjectPutString(ctx, "test", key, key, ""), "failed to create unrelated object")
+	}
+
+	err = store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2})
+	r.NoError(err, "failed to remove older backups")
+
+	segments, err := conn.ObjectNamesAll(ctx, "test_segments", nil)
+	r.NoError(err, "failed to list segments")
+	r.Len(segments, 3, "segments of the removed large object should be deleted")
+
+	for _, key := range unrelated {
+		_, _, err = conn.Object(ctx, "test", key)
+		r.NoError(err, "unrelated object %s should be kept", key)
+	}
+
+	latest, err := store.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal(path.Join("backups", "db", names[2]), latest)
+
+	local, err := store.Retrieve(latest)
+	r.NoError(err, "failed to retrieve backup")
+	actual, err := os.ReadFile(local)
+	r.NoError(err, "failed to read retrieved file")
+	r.Equal(contents[names[2]], actual, "the segments should be joined on download")
+
+	store.Close()
+	r.NoFileExists(local, "retrieved file should be removed on close")
+
+	_, err = store.Retrieve(path.Join("backups", "db", names[0]))
+	r.ErrorContains(err, "failed to download Swift object", "removed backups cannot be retrieved")
+	r.NoFileExists(path.Join(tmp, names[0]), "a failed download should not leave a file")
+
+	// a failed read must not publish the manifest nor leave the uploaded segments behind
+	failing := io.MultiReader(bytes.NewReader(bytes.Repeat([]byte("d"), 1500)), iotest.ErrReader(errors.New("read failed")))
+	err = store.uploadLargeObject(ctx, conn, failing, "backups/db/test-20250104000000.sql", 2000)
+	r.ErrorContains(err, "read failed")
+	_, _, err = conn.Object(ctx, "test", "backups/db/test-20250104000000.sql")
+	r.ErrorIs(err, swift.ObjectNotFound, "a failed upload should not create the object")
+	segments, err = conn.ObjectNamesAll(ctx, "test_segments", nil)
+	r.NoError(err, "failed to list segments")
+	r.Len(segments, 3, "segments of the failed upload should be removed")
+
+	store.APIKey = "wrong"
+	_, err = store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "cannot authenticate", "invalid credentials should be reported")
+}
+
+func TestSwiftSegmentSize(t *testing.T) {
+	r := require.New(t)
+
+	store := &SwiftConfig{}
+	r.Equal(int64(swiftDefaultSegmentSize), store.segmentSize(1024*1024*1024), "the default segment size should be used")
+
+	size := int64(300) * 1024 * 1024 * 1024
+	segments := (size + store.segmentSize(size) - 1) / store.segmentSize(size)
+	r.LessOrEqual(segments, int64(swiftMaxSegments), "big files should fit on the manifest")
+
+	store = &SwiftConfig{SegmentSize: 512}
+	r.Equal(int64(512), store.segmentSize(2000), "the configured segment size should be used")
+}
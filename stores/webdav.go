@@ -0,0 +1,306 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// WebDAVConfig has the config options for the WebDAV service
+type WebDAVConfig struct {
+	URL             string
+	User            string
+	Password        string
+	Prefix          string
+	KeepAfterUpload bool
+	SaveDir         string
+	retrievedFile   string
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func (w *WebDAVConfig) do(method, remotePath string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	base, err := url.Parse(w.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV url %s, %v", w.URL, err)
+	}
+
+	req, err := http.NewRequest(method, base.JoinPath(remotePath).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s request, %v", method, err)
+	}
+
+	if w.User != "" || w.Password != "" {
+		req.SetBasicAuth(w.User, w.Password)
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if f, ok := body.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			req.ContentLength = info.Size()
+		}
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+func (w *WebDAVConfig) request(method, remotePath string, body io.Reader, headers map[string]string, expected ...int) error {
+	res, err := w.do(method, remotePath, body, headers)
+	if err != nil {
+		return fmt.Errorf("%s %s failed, %v", method, remotePath, err)
+	}
+
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	for _, code := range expected {
+		if res.StatusCode == code {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s %s failed with status %s", method, remotePath, res.Status)
+}
+
+func (w *WebDAVConfig) mkcolAll(dir string) error {
+	current := ""
+	for _, part := range strings.Split(path.Clean(dir), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+
+		current = path.Join(current, part)
+		// 405 is returned when the collection already exists
+		err := w.request("MKCOL", current+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Store saves a file to a remote WebDAV server
+func (w *WebDAVConfig) Store(filepath, prefix, filename string) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q, %v", filepath, err)
+	}
+
+	defer func(f *os.File) {
+		closeErr := f.Close()
+		if closeErr != nil {
+			slog.Error("Cannot close file", "path", filepath, "error", closeErr)
+		}
+	}(f)
+
+	if !w.KeepAfterUpload {
+		defer func() {
+			slog.Info("Removing source file", "path", filepath)
+			if err = os.Remove(filepath); err != nil {
+				slog.Warn("Cannot remove file", "path", filepath, "error", err)
+			}
+		}()
+	}
+
+	key := path.Clean(path.Join(w.Prefix, prefix, filename))
+
+	if err = w.mkcolAll(path.Dir(key)); err != nil {
+		return fmt.Errorf("cannot create remote collection, %v", err)
+	}
+
+	err = w.request(http.MethodPut, key, f, nil, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to upload file, %v", err)
+	}
+
+	slog.Debug("File uploaded", "location", key)
+
+	return nil
+}
+
+func (w *WebDAVConfig) getFileListing(basedir, namePrefix string) ([]string, error) {
+	dir := path.Clean(path.Join(w.Prefix, basedir))
+
+	res, err := w.do("PROPFIND", dir+"/", strings.NewReader(propfindBody), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PROPFIND %s failed, %v", dir, err)
+	}
+
+	defer res.Body.Close()
+
+	// the collection doesn't exist yet
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s failed with status %s", dir, res.Status)
+	}
+
+	var ms webdavMultistatus
+	if err = xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("cannot parse PROPFIND response, %v", err)
+	}
+
+	re := generatePattern(namePrefix)
+
+	var files []string
+	for _, r := range ms.Responses {
+		isDir := false
+		for _, p := range r.Propstat {
+			if p.Prop.ResourceType.Collection != nil {
+				isDir = true
+			}
+		}
+		if isDir {
+			continue
+		}
+
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			slog.Warn("Invalid href on PROPFIND response", "href", r.Href)
+			continue
+		}
+
+		// ignore files not created by this program
+		name := path.Base(href.Path)
+		if re.MatchString(name) {
+			files = append(files, path.Join(dir, name))
+		}
+	}
+
+	return files, nil
+}
+
+// RemoveOlderBackups keeps the most recent backups of the WebDAV server and deletes the old ones
+func (w *WebDAVConfig) RemoveOlderBackups(basedir, namePrefix string, policy RetentionPolicy) error {
+	files, err := w.getFileListing(basedir, namePrefix)
+	if err != nil {
+		return fmt.Errorf("couldn't list WebDAV files, %v", err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	expired := policy.expired(files)
+	deleted := 0
+
+	if len(expired) > 0 {
+		for _, file := range expired {
+			err = w.request(http.MethodDelete, file, nil, nil, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+			if err != nil {
+				slog.Error("Failed to remove remote file", "name", file, "error", err)
+			} else {
+				deleted++
+			}
+		}
+
+		slog.Debug("Deleted objects from WebDAV server", "count", deleted, "path", path.Join(w.Prefix, basedir))
+	}
+
+	return nil
+}
+
+// FindLatestBackup returns the most recent backup of the WebDAV server
+func (w *WebDAVConfig) FindLatestBackup(basedir, namePrefix string) (string, error) {
+	files, err := w.getFileListing(basedir, namePrefix)
+	if err != nil {
+		return "", fmt.Errorf("couldn't list WebDAV files, %v", err)
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("cannot find a recent backup on %s/%s", w.URL, w.Prefix)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files[0], nil
+}
+
+// Retrieve downloads a remote file to the local filesystem
+func (w *WebDAVConfig) Retrieve(remotePath string) (string, error) {
+	res, err := w.do(http.MethodGet, remotePath, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("GET %s failed, %v", remotePath, err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s failed with status %s", remotePath, res.Status)
+	}
+
+	filepath := path.Join(w.SaveDir, path.Base(remotePath))
+	f, err := os.Create(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer f.Close()
+
+	if _, err = io.Copy(f, res.Body); err != nil {
+		removePartialDownload(filepath)
+		return "", fmt.Errorf("failed to download remote file, %v", err)
+	}
+
+	slog.Debug("File downloaded", "location", filepath)
+	w.retrievedFile = filepath
+
+	return filepath, nil
+}
+
+// Close deinitializes the store (remove downloaded file)
+func (w *WebDAVConfig) Close() {
+	if w.retrievedFile != "" {
+		if err := os.Remove(w.retrievedFile); err != nil {
+			slog.Warn("Cannot remove file", "path", w.retrievedFile, "error", err)
+		}
+
+		w.retrievedFile = ""
+	}
+}
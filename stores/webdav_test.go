@@ -0,0 +1,138 @@
+/*
+Copyright 2025 codestation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stores
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVStoreRetrieve(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	fs := webdav.NewMemFS()
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "test" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	store := &WebDAVConfig{
+		URL:      server.URL + "/dav",
+		User:     "test",
+		Password: "secret",
+		Prefix:   "backups",
+		SaveDir:  tmp,
+	}
+
+	// the collection is missing until the first upload
+	r.NoError(store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2}), "a missing collection has nothing to remove")
+	latest, err := store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "cannot find a recent backup", "no backups should be found on an empty server")
+	r.Empty(latest)
+
+	names := []string{"test-20250101000000.sql", "test-20250102000000.sql", "test-20250103000000.sql"}
+	for _, name := range names {
+		src := path.Join(tmp, name)
+		err = os.WriteFile(src, []byte(name), 0o600)
+		r.NoError(err, "failed to create backup file")
+
+		err = store.Store(src, "db", name)
+		r.NoError(err, "failed to store file")
+		r.NoFileExists(src, "source file should be removed after upload")
+	}
+
+	ctx := context.Background()
+	notes, err := fs.OpenFile(ctx, "/backups/db/notes.txt", os.O_CREATE|os.O_WRONLY, 0o600)
+	r.NoError(err, "failed to create unrelated file")
+	r.NoError(notes.Close())
+	// a collection named like a backup must not be listed, it would be the latest one
+	r.NoError(fs.Mkdir(ctx, "/backups/db/test-20250104000000.sql", 0o755))
+
+	err = store.RemoveOlderBackups("db", "test", RetentionPolicy{Keep: 2})
+	r.NoError(err, "failed to remove older backups")
+
+	_, err = fs.Stat(ctx, path.Join("/backups", "db", names[0]))
+	r.ErrorIs(err, os.ErrNotExist, "oldest backup should be removed")
+
+	for _, name := range []string{"notes.txt", "test-20250104000000.sql"} {
+		_, err = fs.Stat(ctx, path.Join("/backups", "db", name))
+		r.NoError(err, "%s should be kept", name)
+	}
+
+	latest, err = store.FindLatestBackup("db", "test")
+	r.NoError(err, "failed to find latest backup")
+	r.Equal(path.Join("backups", "db", names[2]), latest)
+
+	local, err := store.Retrieve(latest)
+	r.NoError(err, "failed to retrieve backup")
+	actual, err := os.ReadFile(local)
+	r.NoError(err, "failed to read retrieved file")
+	r.Equal(names[2], string(actual))
+
+	store.Close()
+	r.NoFileExists(local, "retrieved file should be removed on close")
+
+	_, err = store.Retrieve(path.Join("backups", "db", names[0]))
+	r.ErrorContains(err, "404", "removed backups cannot be retrieved")
+
+	// the server rejects the credentials instead of returning an empty listing
+	store.Password = "wrong"
+	_, err = store.FindLatestBackup("db", "test")
+	r.ErrorContains(err, "401", "invalid credentials should be reported")
+
+	src := path.Join(tmp, "test-20250105000000.sql")
+	r.NoError(os.WriteFile(src, []byte("backup"), 0o600), "failed to create backup file")
+	err = store.Store(src, "db", "test-20250105000000.sql")
+	r.ErrorContains(err, "cannot create remote collection", "invalid credentials should be reported")
+}
+
+func TestWebDAVRetrieveTruncated(t *testing.T) {
+	r := require.New(t)
+	tmp := t.TempDir()
+
+	// the connection is closed before the announced length is sent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		_, _ = io.WriteString(w, "truncated")
+	}))
+	defer server.Close()
+
+	store := &WebDAVConfig{URL: server.URL, SaveDir: tmp}
+
+	_, err := store.Retrieve("backups/db/test-20250101000000.sql")
+	r.ErrorContains(err, "failed to download remote file")
+	r.NoFileExists(path.Join(tmp, "test-20250101000000.sql"), "a failed download should not leave a file")
+}
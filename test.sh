@@ -1,12 +1,66 @@
 go run main.go backup postgres s3
+go run main.go backup postgres azure
+go run main.go backup postgres gcs
 go run main.go backup postgres filesystem
+go run main.go backup postgres sftp
+go run main.go backup postgres webdav
+go run main.go backup postgres ftp
+go run main.go backup postgres http
+go run main.go backup postgres swift
+go run main.go backup postgres rclone
+go run main.go backup postgres mirror
 go run main.go backup mysql s3
+go run main.go backup mysql azure
+go run main.go backup mysql gcs
 go run main.go backup mysql filesystem
+go run main.go backup mysql sftp
+go run main.go backup mysql webdav
+go run main.go backup mysql ftp
+go run main.go backup mysql http
+go run main.go backup mysql swift
+go run main.go backup mysql rclone
+go run main.go backup mysql mirror
 go run main.go backup tarball s3
+go run main.go backup tarball azure
+go run main.go backup tarball gcs
 go run main.go backup tarball filesystem
+go run main.go backup tarball sftp
+go run main.go backup tarball webdav
+go run main.go backup tarball ftp
+go run main.go backup tarball http
+go run main.go backup tarball swift
+go run main.go backup tarball rclone
+go run main.go backup tarball mirror
 go run main.go restore postgres s3
+go run main.go restore postgres azure
+go run main.go restore postgres gcs
 go run main.go restore postgres filesystem
+go run main.go restore postgres sftp
+go run main.go restore postgres webdav
+go run main.go restore postgres ftp
+go run main.go restore postgres http
+go run main.go restore postgres swift
+go run main.go restore postgres rclone
+go run main.go restore postgres mirror
 go run main.go restore mysql s3
+go run main.go restore mysql azure
+go run main.go restore mysql gcs
 go run main.go restore mysql filesystem
+go run main.go restore mysql sftp
+go run main.go restore mysql webdav
+go run main.go restore mysql ftp
+go run main.go restore mysql http
+go run main.go restore mysql swift
+go run main.go restore mysql rclone
+go run main.go restore mysql mirror
 go run main.go restore tarball s3
+go run main.go restore tarball azure
+go run main.go restore tarball gcs
 go run main.go restore tarball filesystem
+go run main.go restore tarball sftp
+go run main.go restore tarball webdav
+go run main.go restore tarball ftp
+go run main.go restore tarball http
+go run main.go restore tarball swift
+go run main.go restore tarball rclone
+go run main.go restore tarball mirror